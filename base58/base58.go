@@ -22,7 +22,7 @@ func Encode(input []byte) []byte {
 	}
 
 	ReverseBytes(result)
-	for b := range input {
+	for _, b := range input {
 		if b == 0x00 {
 			result = append([]byte{alphabet[0]}, result...)
 		} else {
@@ -37,9 +37,11 @@ func Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
+	for _, b := range input {
+		if b == alphabet[0] {
 			zeroBytes++
+		} else {
+			break
 		}
 	}
 
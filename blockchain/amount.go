@@ -0,0 +1,63 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Amount int64
+
+const (
+	Coin          Amount = 100000000
+	MaxMoney      Amount = 21000000 * Coin
+	DustThreshold Amount = 546
+	coinDecimals         = 8
+)
+
+func (a Amount) String() string {
+	sign := ""
+	if a < 0 {
+		sign, a = "-", -a
+	}
+	return fmt.Sprintf("%v%d.%0*d", sign, a/Coin, coinDecimals, a%Coin)
+}
+
+func (a Amount) Valid() bool {
+	return a >= 0 && a <= MaxMoney
+}
+
+func ParseAmount(s string) (Amount, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, errors.New("empty amount")
+	}
+	if len(frac) > coinDecimals {
+		return 0, fmt.Errorf("more than %v decimal places", coinDecimals)
+	}
+	var a Amount
+	for _, c := range whole + frac + strings.Repeat("0", coinDecimals-len(frac)) {
+		if c < '0' || c > '9' {
+			return 0, errors.New("invalid amount")
+		}
+		a = a*10 + Amount(c-'0')
+		if a > MaxMoney {
+			return 0, errors.New("amount out of range")
+		}
+	}
+	return a, nil
+}
+
+func SumAmounts(amounts ...Amount) (Amount, error) {
+	var sum Amount
+	for _, a := range amounts {
+		if !a.Valid() {
+			return 0, errors.New("amount out of range")
+		}
+		sum += a
+		if !sum.Valid() {
+			return 0, errors.New("sum of amounts out of range")
+		}
+	}
+	return sum, nil
+}
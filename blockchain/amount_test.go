@@ -0,0 +1,58 @@
+package blockchain
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		s      string
+		amount Amount
+		ok     bool
+	}{
+		{"1", Coin, true},
+		{"1.25", 125000000, true},
+		{".5", 50000000, true},
+		{"5.", 5 * Coin, true},
+		{"0.00000001", 1, true},
+		{"21000000", MaxMoney, true},
+		{"0", 0, true},
+		{"", 0, false},
+		{".", 0, false},
+		{"0.000000001", 0, false},
+		{"21000000.00000001", 0, false},
+		{"99999999999999999999", 0, false},
+		{"-1", 0, false},
+		{"+1", 0, false},
+		{"1e8", 0, false},
+		{"1.2.3", 0, false},
+		{" 1", 0, false},
+	}
+	for _, test := range tests {
+		amount, err := ParseAmount(test.s)
+		if (err == nil) != test.ok || amount != test.amount {
+			t.Errorf("ParseAmount(%q) = %v, %v", test.s, amount, err)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	for _, a := range []Amount{0, 1, Coin, 125000000, MaxMoney} {
+		if parsed, err := ParseAmount(a.String()); err != nil || parsed != a {
+			t.Errorf("ParseAmount(%q) = %v, %v", a.String(), parsed, err)
+		}
+	}
+	if s := Amount(-150000000).String(); s != "-1.50000000" {
+		t.Errorf("Amount(-150000000).String() = %v", s)
+	}
+}
+
+func TestSumAmounts(t *testing.T) {
+	if sum, err := SumAmounts(Coin, 2*Coin); err != nil || sum != 3*Coin {
+		t.Errorf("SumAmounts = %v, %v", sum, err)
+	}
+	if _, err := SumAmounts(MaxMoney, 1); err == nil {
+		t.Error("sum above MaxMoney is accepted")
+	}
+	if _, err := SumAmounts(Coin, -1); err == nil {
+		t.Error("negative amount is accepted")
+	}
+}
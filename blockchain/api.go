@@ -0,0 +1,236 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// maxTemplates is the number of templates kept for every miner address.
+const maxTemplates = 16
+
+var errNotPoW = errors.New("block templates are served only for proof of work")
+
+type Server struct {
+	mu        sync.Mutex
+	mux       *http.ServeMux
+	templates map[string]*Block
+	order     map[string][]string
+	minute    int
+	requests  map[string]int
+	bans      map[string]int
+	outbound  []string
+	relay     *BFTRelay
+}
+
+func NewServer() *Server {
+	s := &Server{
+		mux:       http.NewServeMux(),
+		templates: make(map[string]*Block),
+		order:     make(map[string][]string),
+		requests:  make(map[string]int),
+	}
+	s.mux.HandleFunc("GET /getblocktemplate", s.getBlockTemplate)
+	s.mux.HandleFunc("POST /submitblock", s.submitBlock)
+	s.mux.HandleFunc("GET /getheaders", s.getHeaders)
+	s.mux.HandleFunc("GET /getblock", s.getBlock)
+	s.mux.HandleFunc("GET /getaddr", s.getAddr)
+	s.mux.HandleFunc("POST /addr", s.addr)
+	s.mux.HandleFunc("POST /bft/proposal", s.bftProposal)
+	s.mux.HandleFunc("POST /bft/vote", s.bftVote)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.admit(r) {
+		writeError(w, http.StatusForbidden, errors.New("banned"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s)
+}
+
+// withChain opens database for a single request. Requests are serialized,
+// since database file is locked while it is open.
+func (s *Server) withChain(fn func(bc *Blockchain, u *UTXOSet) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db := GetDatabase()
+	defer db.Close()
+	bc := db.Blockchain()
+	u := db.UTXOSet()
+	if u == nil {
+		u = new(UTXOSet)
+		*u = make(UTXOSet)
+		u.Index(bc)
+		db.SetUTXOSet(u)
+	}
+	return fn(bc, u)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+type TemplateTx struct {
+	Data string `json:"data"`
+	ID   string `json:"txid"`
+	Fee  Amount `json:"fee"`
+}
+
+// BlockTemplate describes a block for external miners. Miner searches for
+// the nonce, which is the last 8 bytes of the header in little-endian order,
+// until proof of work hash of the header is below target, and submits the
+// header back.
+type BlockTemplate struct {
+	Version       int          `json:"version"`
+	Height        int          `json:"height"`
+	PrevHash      string       `json:"previousblockhash"`
+	Timestamp     int          `json:"curtime"`
+	Target        string       `json:"target"`
+	Difficulty    int          `json:"difficulty"`
+	PoW           string       `json:"pow"`
+	CoinbaseValue Amount       `json:"coinbasevalue"`
+	Coinbase      string       `json:"coinbasetxn"`
+	Transactions  []TemplateTx `json:"transactions"`
+	MerkleRoot    string       `json:"merkleroot"`
+	Header        string       `json:"header"`
+}
+
+func newBlockTemplate(bc *Blockchain, block *Block) *BlockTemplate {
+	tmpl := &BlockTemplate{
+		Version:       block.Header.Version,
+		Height:        block.Header.Height,
+		PrevHash:      fmt.Sprintf("%x", block.Header.PrevHash),
+		Timestamp:     block.Header.Timestamp,
+		Target:        fmt.Sprintf("%x", Target(bc.Difficulty)),
+		Difficulty:    bc.Difficulty,
+		PoW:           params.PoW,
+		CoinbaseValue: block.Txs[0].OutputValue(),
+		Coinbase:      fmt.Sprintf("%x", block.Txs[0].Bytes()),
+		Transactions:  make([]TemplateTx, 0, len(block.Txs)-1),
+		MerkleRoot:    fmt.Sprintf("%x", block.Header.MerkleRoot),
+		Header:        fmt.Sprintf("%x", block.Header.Bytes()),
+	}
+	for _, tx := range block.Txs[1:] {
+		txid := fmt.Sprintf("%x", tx.ID())
+		tmpl.Transactions = append(tmpl.Transactions, TemplateTx{
+			fmt.Sprintf("%x", tx.Bytes()), txid, bc.Mempool.Entries[txid].Fee,
+		})
+	}
+	return tmpl
+}
+
+func (s *Server) getBlockTemplate(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("address")
+	lock, err := AddressScript(addr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address: %v", err))
+		return
+	}
+	var tmpl *BlockTemplate
+	err = s.withChain(func(bc *Blockchain, u *UTXOSet) error {
+		if _, ok := params.Engine().(PoWEngine); !ok {
+			return errNotPoW
+		}
+		block := bc.BlockTemplate(lock)
+		tmpl = newBlockTemplate(bc, block)
+		s.addTemplate(addr, tmpl.MerkleRoot, block)
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tmpl)
+}
+
+// addTemplate keeps the latest templates of every miner address, so that a
+// solved header can be matched with transactions of its block by merkle root.
+func (s *Server) addTemplate(addr, merkleRoot string, block *Block) {
+	if _, ok := s.templates[merkleRoot]; !ok {
+		s.order[addr] = append(s.order[addr], merkleRoot)
+	}
+	s.templates[merkleRoot] = block
+	if order := s.order[addr]; len(order) > maxTemplates {
+		delete(s.templates, order[0])
+		s.order[addr] = order[1:]
+	}
+}
+
+type SubmitBlockRequest struct {
+	Header string `json:"header"`
+	Block  string `json:"block"`
+}
+
+func (s *Server) submitBlock(w http.ResponseWriter, r *http.Request) {
+	var req SubmitBlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.misbehave(r, ScoreMalformed, "malformed block submission")
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var block *Block
+	var err error
+	switch {
+	case req.Block != "":
+		if block, err = decodeHex(req.Block, DecodeBlock); err != nil {
+			s.misbehave(r, ScoreMalformed, "malformed block")
+		}
+	case req.Header != "":
+		var header *BlockHeader
+		if header, err = decodeHex(req.Header, DecodeBlockHeader); err != nil {
+			s.misbehave(r, ScoreMalformed, "malformed block header")
+		} else {
+			s.mu.Lock()
+			tmpl := s.templates[fmt.Sprintf("%x", header.MerkleRoot)]
+			s.mu.Unlock()
+			if tmpl == nil {
+				err = errors.New("unknown block template")
+			} else {
+				block = &Block{*header, tmpl.Txs, nil}
+			}
+		}
+	default:
+		err = errors.New("header or block is required")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = s.withChain(func(bc *Blockchain, u *UTXOSet) error {
+		return bc.SubmitBlock(block, u)
+	})
+	if err != nil {
+		if !errors.Is(err, errStaleBlock) {
+			s.misbehave(r, ScoreRejectedBlock, "rejected block")
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"hash":   fmt.Sprintf("%x", block.Header.Hash),
+		"height": block.Header.Height,
+	})
+}
+
+func decodeHex[T any](s string, decode func([]byte) (T, error)) (T, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		var zero T
+		return zero, errors.New("invalid hex string")
+	}
+	return decode(data)
+}
@@ -0,0 +1,883 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// bftTimeout is the timeout of the first round of every step, which
+	// grows by bftTimeoutDelta with every round.
+	bftTimeout      = 500 * time.Millisecond
+	bftTimeoutDelta = 250 * time.Millisecond
+	bftInboxSize    = 1024
+	bftSendTimeout  = 5 * time.Second
+)
+
+type VoteType uint32
+
+const (
+	Prevote   VoteType = 1
+	Precommit VoteType = 2
+)
+
+const (
+	stepPropose = iota
+	stepPrevote
+	stepPrecommit
+)
+
+// Quorum is the number of votes of more than 2/3 of n validators.
+func Quorum(n int) int {
+	return n*2/3 + 1
+}
+
+// Validators returns sorted BFT validator set of chain params.
+func Validators() []string {
+	return NewSnapshot(params.Signers).Signers
+}
+
+// Proposer returns the validator which proposes block at height in round.
+func Proposer(validators []string, height, round int) string {
+	return validators[(height+round)%len(validators)]
+}
+
+// Vote is a prevote or precommit of a validator for block with hash in a
+// round, nil hash votes for no block.
+type Vote struct {
+	Type      VoteType
+	Height    int
+	Round     int
+	BlockHash []byte
+	PubKey    []byte
+	Signature []byte
+}
+
+func NewVote(w *Wallet, t VoteType, height, round int, hash []byte) *Vote {
+	v := &Vote{Type: t, Height: height, Round: round, BlockHash: hash, PubKey: w.PubKey()}
+	v.Signature = w.Sign(v.SigHash())
+	return v
+}
+
+func (v *Vote) SigHash() []byte {
+	e := &Encoder{}
+	e.WriteUint32(uint32(v.Type))
+	e.WriteUint32(uint32(v.Height))
+	e.WriteUint32(uint32(v.Round))
+	e.WriteHash(v.BlockHash)
+	hash := sha256.Sum256(e.Bytes())
+	return hash[:]
+}
+
+func (v *Vote) Bytes() []byte {
+	e := &Encoder{}
+	e.WriteUint32(uint32(v.Type))
+	e.WriteUint32(uint32(v.Height))
+	e.WriteUint32(uint32(v.Round))
+	e.WriteHash(v.BlockHash)
+	e.WriteBytes(v.PubKey)
+	e.WriteBytes(v.Signature)
+	return e.Bytes()
+}
+
+func DecodeVote(data []byte) (*Vote, error) {
+	d := NewDecoder(data)
+	v := &Vote{}
+	v.Type = VoteType(d.ReadUint32())
+	v.Height = int(d.ReadUint32())
+	v.Round = int(d.ReadUint32())
+	v.BlockHash = d.ReadHash()
+	v.PubKey = d.ReadBytes()
+	v.Signature = d.ReadBytes()
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if d.Remaining() != 0 {
+		return nil, errors.New("trailing data after vote")
+	}
+	if v.Type != Prevote && v.Type != Precommit {
+		return nil, errors.New("unknown vote type")
+	}
+	return v, nil
+}
+
+func (v *Vote) Verify() bool {
+	return VerifySignature(v.PubKey, v.SigHash(), v.Signature)
+}
+
+func (v *Vote) Validator() string {
+	return Address(version, Hash160(v.PubKey))
+}
+
+// Proposal is a block proposed in a round. POLRound is the round in which
+// the block got prevotes of a quorum, or -1.
+type Proposal struct {
+	Height    int
+	Round     int
+	POLRound  int
+	Block     *Block
+	PubKey    []byte
+	Signature []byte
+}
+
+func (p *Proposal) SigHash() []byte {
+	e := &Encoder{}
+	e.WriteUint32(uint32(p.Height))
+	e.WriteUint32(uint32(p.Round))
+	e.WriteUint32(uint32(p.POLRound + 1))
+	e.WriteHash(p.Block.Header.Hash)
+	hash := sha256.Sum256(e.Bytes())
+	return hash[:]
+}
+
+func (p *Proposal) Bytes() []byte {
+	e := &Encoder{}
+	e.WriteUint32(uint32(p.Height))
+	e.WriteUint32(uint32(p.Round))
+	e.WriteUint32(uint32(p.POLRound + 1))
+	e.WriteBytes(p.Block.Bytes())
+	e.WriteBytes(p.PubKey)
+	e.WriteBytes(p.Signature)
+	return e.Bytes()
+}
+
+func DecodeProposal(data []byte) (*Proposal, error) {
+	d := NewDecoder(data)
+	p := &Proposal{}
+	p.Height = int(d.ReadUint32())
+	p.Round = int(d.ReadUint32())
+	p.POLRound = int(d.ReadUint32()) - 1
+	block := d.ReadBytes()
+	p.PubKey = d.ReadBytes()
+	p.Signature = d.ReadBytes()
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if d.Remaining() != 0 {
+		return nil, errors.New("trailing data after proposal")
+	}
+	var err error
+	if p.Block, err = DecodeBlock(block); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Proposal) Verify() bool {
+	return VerifySignature(p.PubKey, p.SigHash(), p.Signature)
+}
+
+func (p *Proposal) Validator() string {
+	return Address(version, Hash160(p.PubKey))
+}
+
+// CommitSig is a precommit signature of a validator in a commit certificate.
+type CommitSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// Commit is the certificate of a finalized block, precommits of a quorum of
+// validators for the block in Round. It is the seal of BFT blocks.
+type Commit struct {
+	Round      int
+	Signatures []CommitSig
+}
+
+func (c *Commit) Bytes() []byte {
+	e := &Encoder{}
+	e.WriteUint32(uint32(c.Round))
+	e.WriteVarInt(uint64(len(c.Signatures)))
+	for _, s := range c.Signatures {
+		e.WriteBytes(s.PubKey)
+		e.WriteBytes(s.Signature)
+	}
+	return e.Bytes()
+}
+
+func DecodeCommit(data []byte) (*Commit, error) {
+	d := NewDecoder(data)
+	c := &Commit{}
+	c.Round = int(d.ReadUint32())
+	count := d.ReadCount(2)
+	for i := 0; i < count && d.Err() == nil; i++ {
+		c.Signatures = append(c.Signatures, CommitSig{d.ReadBytes(), d.ReadBytes()})
+	}
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if d.Remaining() != 0 {
+		return nil, errors.New("trailing data after commit")
+	}
+	return c, nil
+}
+
+// Verify checks that a quorum of validators precommitted block with header h.
+func (c *Commit) Verify(h *BlockHeader, validators []string) error {
+	signed := make(map[string]bool)
+	for _, s := range c.Signatures {
+		v := &Vote{Precommit, h.Height, c.Round, h.ComputeHash(), s.PubKey, s.Signature}
+		validator := v.Validator()
+		if _, ok := slices.BinarySearch(validators, validator); !ok {
+			return fmt.Errorf("commit is signed by unknown validator %v", validator)
+		}
+		if signed[validator] {
+			return fmt.Errorf("commit is signed twice by %v", validator)
+		}
+		if !v.Verify() {
+			return fmt.Errorf("invalid precommit signature of %v", validator)
+		}
+		signed[validator] = true
+	}
+	if len(signed) < Quorum(len(validators)) {
+		return fmt.Errorf("commit has %v of %v required precommits", len(signed), Quorum(len(validators)))
+	}
+	return nil
+}
+
+// BFTMessage carries either a proposal or a vote.
+type BFTMessage struct {
+	Proposal *Proposal
+	Vote     *Vote
+}
+
+func (m *BFTMessage) height() int {
+	if m.Proposal != nil {
+		return m.Proposal.Height
+	}
+	return m.Vote.Height
+}
+
+// BFTTransport broadcasts messages of a validator to other validators.
+type BFTTransport interface {
+	Broadcast(from string, msg *BFTMessage)
+}
+
+// BFTNetwork delivers messages among validators run in process, which is
+// used to test consensus. Offline validators neither send nor receive
+// messages.
+type BFTNetwork struct {
+	Offline map[string]bool
+	inboxes map[string]chan *BFTMessage
+}
+
+func NewBFTNetwork() *BFTNetwork {
+	return &BFTNetwork{make(map[string]bool), make(map[string]chan *BFTMessage)}
+}
+
+// Join must be called for every validator before any message is sent.
+func (n *BFTNetwork) Join(addr string) <-chan *BFTMessage {
+	inbox := make(chan *BFTMessage, bftInboxSize)
+	n.inboxes[addr] = inbox
+	return inbox
+}
+
+func (n *BFTNetwork) Broadcast(from string, msg *BFTMessage) {
+	if n.Offline[from] {
+		return
+	}
+	for addr, inbox := range n.inboxes {
+		if addr == from || n.Offline[addr] {
+			continue
+		}
+		select {
+		case inbox <- msg:
+		default:
+		}
+	}
+}
+
+type bftTimeoutEvent struct {
+	step, round int
+}
+
+// BFTNode runs Tendermint rounds of a validator at Height. Every round a
+// proposer proposes a block, validators prevote for it unless they are
+// locked on another block, and once a quorum prevotes for the block they
+// lock on it and precommit it. Block is decided when a quorum precommits it.
+// Steps time out without a quorum, and a round without decision is followed
+// by the next one with the next proposer.
+type BFTNode struct {
+	Wallet     *Wallet
+	Validators []string
+	Height     int
+	Network    BFTTransport
+	// Propose returns a new block to propose.
+	Propose func() *Block
+	// Valid checks a proposed block.
+	Valid func(*Block) bool
+
+	round       int
+	step        int
+	lockedBlock *Block
+	lockedRound int
+	validBlock  *Block
+	validRound  int
+	proposals   map[int]*Proposal
+	votes       map[VoteType]map[int]map[string]*Vote
+	scheduled   map[bftTimeoutEvent]bool
+	timeouts    chan bftTimeoutEvent
+}
+
+// Run takes part in consensus with messages of inbox until block is decided
+// or ctx is cancelled. Decided block is sealed with its commit certificate.
+func (n *BFTNode) Run(ctx context.Context, inbox <-chan *BFTMessage) (*Block, error) {
+	n.lockedRound, n.validRound = -1, -1
+	n.proposals = make(map[int]*Proposal)
+	n.votes = map[VoteType]map[int]map[string]*Vote{Prevote: {}, Precommit: {}}
+	n.scheduled = make(map[bftTimeoutEvent]bool)
+	n.timeouts = make(chan bftTimeoutEvent, bftInboxSize)
+	n.startRound(0)
+	for {
+		for n.check() {
+		}
+		if block := n.decision(); block != nil {
+			return block, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case msg := <-inbox:
+			n.receive(msg)
+		case t := <-n.timeouts:
+			n.timeout(t)
+		}
+	}
+}
+
+func (n *BFTNode) address() string {
+	return n.Wallet.Address()
+}
+
+func (n *BFTNode) isValidator(addr string) bool {
+	_, ok := slices.BinarySearch(n.Validators, addr)
+	return ok
+}
+
+func (n *BFTNode) receive(msg *BFTMessage) {
+	if p := msg.Proposal; p != nil {
+		switch {
+		case p.Height != n.Height || p.Block == nil || n.proposals[p.Round] != nil:
+		case p.Validator() != Proposer(n.Validators, n.Height, p.Round):
+		case !p.Verify():
+		default:
+			n.proposals[p.Round] = p
+		}
+	}
+	if v := msg.Vote; v != nil && v.Height == n.Height && n.votes[v.Type] != nil && n.isValidator(v.Validator()) && v.Verify() {
+		votes := n.votes[v.Type][v.Round]
+		if votes == nil {
+			votes = make(map[string]*Vote)
+			n.votes[v.Type][v.Round] = votes
+		}
+		if votes[v.Validator()] == nil {
+			votes[v.Validator()] = v
+		}
+	}
+}
+
+func (n *BFTNode) send(msg *BFTMessage) {
+	n.receive(msg)
+	n.Network.Broadcast(n.address(), msg)
+}
+
+func (n *BFTNode) vote(t VoteType, hash []byte) {
+	n.send(&BFTMessage{Vote: NewVote(n.Wallet, t, n.Height, n.round, hash)})
+	if t == Prevote {
+		n.step = stepPrevote
+	} else {
+		n.step = stepPrecommit
+	}
+}
+
+func (n *BFTNode) schedule(step, round int) {
+	t := bftTimeoutEvent{step, round}
+	if n.scheduled[t] {
+		return
+	}
+	n.scheduled[t] = true
+	time.AfterFunc(bftTimeout+time.Duration(round)*bftTimeoutDelta, func() {
+		select {
+		case n.timeouts <- t:
+		default:
+		}
+	})
+}
+
+func (n *BFTNode) startRound(round int) {
+	n.round, n.step = round, stepPropose
+	if Proposer(n.Validators, n.Height, round) != n.address() {
+		n.schedule(stepPropose, round)
+		return
+	}
+	p := &Proposal{Height: n.Height, Round: round, POLRound: -1, Block: n.validBlock, PubKey: n.Wallet.PubKey()}
+	if p.Block != nil {
+		p.POLRound = n.validRound
+	} else {
+		p.Block = n.Propose()
+	}
+	p.Signature = n.Wallet.Sign(p.SigHash())
+	n.send(&BFTMessage{Proposal: p})
+}
+
+func (n *BFTNode) timeout(t bftTimeoutEvent) {
+	if t.round != n.round {
+		return
+	}
+	switch {
+	case t.step == stepPropose && n.step == stepPropose:
+		n.vote(Prevote, nil)
+	case t.step == stepPrevote && n.step == stepPrevote:
+		n.vote(Precommit, nil)
+	case t.step == stepPrecommit:
+		n.startRound(n.round + 1)
+	}
+}
+
+// count returns number of votes of type in round for hash, and of all votes
+// in round.
+func (n *BFTNode) count(t VoteType, round int, hash []byte) (int, int) {
+	votes := n.votes[t][round]
+	matching := 0
+	for _, v := range votes {
+		if bytes.Equal(v.BlockHash, hash) {
+			matching++
+		}
+	}
+	return matching, len(votes)
+}
+
+func (n *BFTNode) locked(b *Block) bool {
+	return n.lockedBlock != nil && bytes.Equal(n.lockedBlock.Header.Hash, b.Header.Hash)
+}
+
+// check applies the first rule of the round which changes state, and
+// reports whether any did.
+func (n *BFTNode) check() bool {
+	q := Quorum(len(n.Validators))
+	p := n.proposals[n.round]
+	if p != nil && n.step == stepPropose {
+		hash := p.Block.Header.Hash
+		if p.POLRound == -1 {
+			if !n.Valid(p.Block) || n.lockedRound != -1 && !n.locked(p.Block) {
+				hash = nil
+			}
+			n.vote(Prevote, hash)
+			return true
+		}
+		if polVotes, _ := n.count(Prevote, p.POLRound, hash); p.POLRound < n.round && polVotes >= q {
+			if !n.Valid(p.Block) || n.lockedRound > p.POLRound && !n.locked(p.Block) {
+				hash = nil
+			}
+			n.vote(Prevote, hash)
+			return true
+		}
+	}
+	if _, total := n.count(Prevote, n.round, nil); n.step == stepPrevote && total >= q {
+		n.schedule(stepPrevote, n.round)
+	}
+	if p != nil && n.step >= stepPrevote && n.validRound != n.round {
+		if votes, _ := n.count(Prevote, n.round, p.Block.Header.Hash); votes >= q && n.Valid(p.Block) {
+			if n.step == stepPrevote {
+				n.lockedBlock, n.lockedRound = p.Block, n.round
+				n.vote(Precommit, p.Block.Header.Hash)
+			}
+			n.validBlock, n.validRound = p.Block, n.round
+			return true
+		}
+	}
+	if nilVotes, _ := n.count(Prevote, n.round, nil); n.step == stepPrevote && nilVotes >= q {
+		n.vote(Precommit, nil)
+		return true
+	}
+	if _, total := n.count(Precommit, n.round, nil); total >= q {
+		n.schedule(stepPrecommit, n.round)
+	}
+	f := len(n.Validators) - q
+	senders := make(map[int]map[string]bool)
+	for _, votes := range n.votes {
+		for round, byValidator := range votes {
+			if round <= n.round {
+				continue
+			}
+			if senders[round] == nil {
+				senders[round] = make(map[string]bool)
+			}
+			for addr := range byValidator {
+				senders[round][addr] = true
+			}
+		}
+	}
+	for round, addrs := range senders {
+		if len(addrs) > f {
+			n.startRound(round)
+			return true
+		}
+	}
+	return false
+}
+
+// decision returns a block precommitted by a quorum in any round, sealed
+// with the commit certificate.
+func (n *BFTNode) decision() *Block {
+	for round, p := range n.proposals {
+		hash := p.Block.Header.Hash
+		if votes, _ := n.count(Precommit, round, hash); votes < Quorum(len(n.Validators)) || !n.Valid(p.Block) {
+			continue
+		}
+		commit := &Commit{Round: round}
+		for _, addr := range slices.Sorted(maps.Keys(n.votes[Precommit][round])) {
+			if v := n.votes[Precommit][round][addr]; bytes.Equal(v.BlockHash, hash) {
+				commit.Signatures = append(commit.Signatures, CommitSig{v.PubKey, v.Signature})
+			}
+		}
+		block := *p.Block
+		block.Seal = commit.Bytes()
+		return &block
+	}
+	return nil
+}
+
+// RunBFT runs a node for every wallet of network validators which is not
+// offline and returns the first decided block.
+func RunBFT(ctx context.Context, network *BFTNetwork, wallets []*Wallet, height int, propose func(w *Wallet) *Block, valid func(*Block) bool) (*Block, error) {
+	validators := Validators()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var nodes []*BFTNode
+	var inboxes []<-chan *BFTMessage
+	for _, w := range wallets {
+		nodes = append(nodes, &BFTNode{
+			Wallet:     w,
+			Validators: validators,
+			Height:     height,
+			Network:    network,
+			Propose:    func() *Block { return propose(w) },
+			Valid:      valid,
+		})
+		inboxes = append(inboxes, network.Join(w.Address()))
+	}
+	type result struct {
+		block *Block
+		err   error
+	}
+	results := make(chan result, len(nodes))
+	running := 0
+	for i, node := range nodes {
+		if network.Offline[node.Wallet.Address()] {
+			continue
+		}
+		running++
+		go func() {
+			block, err := node.Run(ctx, inboxes[i])
+			results <- result{block, err}
+		}()
+	}
+	if running == 0 {
+		return nil, errors.New("no validators are running")
+	}
+	var err error
+	for range running {
+		r := <-results
+		if r.err == nil {
+			return r.block, nil
+		}
+		err = r.err
+	}
+	return nil, err
+}
+
+// BFT finalizes every block by Tendermint rounds of validators in Signers of
+// chain params, and a block is added to chain only with commit certificate
+// of a quorum of them. Every node runs a single validator, which exchanges
+// proposals and votes with validators of peers through the relay of its
+// server.
+type BFT struct{}
+
+func (BFT) Prepare(bc *Blockchain, b *Block, m *Miner) error {
+	if m.Signer == nil {
+		return errors.New("signer wallet is required")
+	}
+	if m.Relay == nil {
+		return errors.New("validator runs only with serve --validator")
+	}
+	if _, ok := slices.BinarySearch(Validators(), m.Signer.Address()); !ok {
+		return fmt.Errorf("%v is not a validator", m.Signer.Address())
+	}
+	b.Header.Version = sealedBlockVersion
+	b.Header.Nonce = 0
+	if parent := bc.DB.Block(b.Header.PrevHash); parent != nil {
+		b.Header.Timestamp = max(b.Header.Timestamp, parent.Header.Timestamp+params.BlockPeriod)
+	}
+	b.Header.Hash = b.Header.ComputeHash()
+	b.Seal = nil
+	return nil
+}
+
+// Seal waits until timestamp of the block and runs the validator of signer
+// until validators decide a block of its height. The block is proposed when
+// the validator is the proposer of a round, and the decided block may be a
+// block of another proposer.
+func (BFT) Seal(ctx context.Context, b *Block, m *Miner) (*Block, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Until(time.Unix(int64(b.Header.Timestamp), 0))):
+	}
+	inbox := m.Relay.Join(b.Header.Height)
+	defer m.Relay.Leave()
+	checked := make(map[string]bool)
+	valid := func(p *Block) bool {
+		hash := fmt.Sprintf("%x", p.Header.Hash)
+		if ok, seen := checked[hash]; seen {
+			return ok
+		}
+		db := GetDatabase()
+		defer db.Close()
+		bc := db.Blockchain()
+		u := db.UTXOSet()
+		if u == nil {
+			u = new(UTXOSet)
+			*u = make(UTXOSet)
+			u.Index(bc)
+		}
+		err := VerifyProposal(bc, u, p)
+		if err != nil {
+			fmt.Printf("Rejected proposal %v: %v\n", hash, err)
+		}
+		checked[hash] = err == nil
+		return err == nil
+	}
+	node := &BFTNode{
+		Wallet:     m.Signer,
+		Validators: Validators(),
+		Height:     b.Header.Height,
+		Network:    m.Relay,
+		Propose:    func() *Block { return b },
+		Valid:      valid,
+	}
+	return node.Run(ctx, inbox)
+}
+
+// VerifyProposal checks a proposed block on top of the tip as SubmitBlock
+// does, except for the commit certificate it does not have yet.
+func VerifyProposal(bc *Blockchain, u *UTXOSet, b *Block) error {
+	switch {
+	case !bytes.Equal(b.Header.PrevHash, bc.LastHash()) || b.Header.Height != bc.Height()+1:
+		return errors.New("block does not extend the tip")
+	case b.Header.Version != sealedBlockVersion || !bytes.Equal(b.Header.Hash, b.Hash()):
+		return errors.New("invalid block header")
+	case b.Header.Timestamp > int(time.Now().Unix())+maxFutureDrift:
+		return errors.New("block timestamp is too far in the future")
+	}
+	if parent := bc.DB.Block(b.Header.PrevHash); parent != nil && b.Header.Timestamp < parent.Header.Timestamp+params.BlockPeriod {
+		return errors.New("block is proposed too early")
+	}
+	if err := b.CheckSpends(u); err != nil {
+		return err
+	}
+	if !b.VerifyBody(u) {
+		return errors.New("block is invalid")
+	}
+	return nil
+}
+
+func (BFT) VerifyHeader(bc *Blockchain, b *Block) error {
+	if b.Header.Version != sealedBlockVersion {
+		return errors.New("unexpected block version")
+	}
+	commit, err := DecodeCommit(b.Seal)
+	if err != nil {
+		return err
+	}
+	if err := commit.Verify(&b.Header, Validators()); err != nil {
+		return err
+	}
+	if parent := bc.DB.Block(b.Header.PrevHash); parent != nil && b.Header.Timestamp < parent.Header.Timestamp+params.BlockPeriod {
+		return errors.New("block is committed too early")
+	}
+	if b.Header.Timestamp > int(time.Now().Unix())+maxFutureDrift {
+		return errors.New("block timestamp is too far in the future")
+	}
+	return nil
+}
+
+// BFTRelay carries messages of the validator run by this node to validators
+// of peers over HTTP, and delivers their messages to it. Messages of later
+// heights are kept until the validator reaches them.
+type BFTRelay struct {
+	// Peers returns URLs of peers, which are read once for every height.
+	Peers   func() []string
+	mu      sync.Mutex
+	height  int
+	peers   []string
+	inbox   chan *BFTMessage
+	pending []*BFTMessage
+}
+
+func NewBFTRelay(peers func() []string) *BFTRelay {
+	return &BFTRelay{Peers: peers}
+}
+
+// Join starts delivering messages of height to a new inbox.
+func (r *BFTRelay) Join(height int) <-chan *BFTMessage {
+	peers := r.Peers()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.height, r.peers, r.inbox = height, peers, make(chan *BFTMessage, bftInboxSize)
+	var later []*BFTMessage
+	for _, msg := range r.pending {
+		switch h := msg.height(); {
+		case h == height:
+			r.inbox <- msg
+		case h > height:
+			later = append(later, msg)
+		}
+	}
+	r.pending = later
+	return r.inbox
+}
+
+// Leave stops delivering messages until the next Join.
+func (r *BFTRelay) Leave() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inbox = nil
+}
+
+// Deliver passes a message of a peer to the validator. Messages of decided
+// heights are dropped.
+func (r *BFTRelay) Deliver(msg *BFTMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch h := msg.height(); {
+	case h == r.height && r.inbox != nil:
+		select {
+		case r.inbox <- msg:
+		default:
+		}
+	case h > r.height && len(r.pending) < bftInboxSize:
+		r.pending = append(r.pending, msg)
+	}
+}
+
+// Broadcast posts message to /bft/proposal or /bft/vote of every peer.
+func (r *BFTRelay) Broadcast(from string, msg *BFTMessage) {
+	url, body := "/bft/vote", map[string]string{}
+	if msg.Proposal != nil {
+		url, body["proposal"] = "/bft/proposal", fmt.Sprintf("%x", msg.Proposal.Bytes())
+	} else {
+		body["vote"] = fmt.Sprintf("%x", msg.Vote.Bytes())
+	}
+	r.mu.Lock()
+	peers := r.peers
+	r.mu.Unlock()
+	for _, peer := range peers {
+		go bftSend(peer+url, body)
+	}
+}
+
+func bftSend(url string, body any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bftSendTimeout)
+	defer cancel()
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	var result map[string]any
+	return decodeResponse(resp, &result)
+}
+
+// Validate runs validator w of BFT chain until ctx is cancelled, exchanging
+// proposals and votes with known peers of address manager.
+func (s *Server) Validate(ctx context.Context, w *Wallet) {
+	relay := NewBFTRelay(s.bftPeers)
+	s.mu.Lock()
+	s.relay = relay
+	s.mu.Unlock()
+	m := NewMiner(1)
+	m.Signer, m.Relay = w, relay
+	MineContinuous(ctx, w.LockScript(), m)
+}
+
+func (s *Server) bftPeers() []string {
+	var peers []string
+	s.withPeers(func(am *AddrMan) bool {
+		added := false
+		for _, seed := range params.Seeds {
+			added = am.Add(seed, "seed") || added
+		}
+		peers = am.Candidates()
+		return added
+	})
+	return peers
+}
+
+func (s *Server) bftProposal(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Proposal string `json:"proposal"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.misbehave(r, ScoreMalformed, "malformed proposal")
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p, err := decodeHex(req.Proposal, DecodeProposal)
+	if err != nil {
+		s.misbehave(r, ScoreMalformed, "malformed proposal")
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.deliver(w, r, &BFTMessage{Proposal: p}, p.Validator(), p.Verify())
+}
+
+func (s *Server) bftVote(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Vote string `json:"vote"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.misbehave(r, ScoreMalformed, "malformed vote")
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	v, err := decodeHex(req.Vote, DecodeVote)
+	if err != nil {
+		s.misbehave(r, ScoreMalformed, "malformed vote")
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.deliver(w, r, &BFTMessage{Vote: v}, v.Validator(), v.Verify())
+}
+
+// deliver passes message signed by validator to the validator of this node.
+func (s *Server) deliver(w http.ResponseWriter, r *http.Request, msg *BFTMessage, validator string, verified bool) {
+	if _, ok := slices.BinarySearch(Validators(), validator); !ok || !verified {
+		s.misbehave(r, ScoreMalformed, "message not signed by a validator")
+		writeError(w, http.StatusBadRequest, errors.New("message is not signed by a validator"))
+		return
+	}
+	s.mu.Lock()
+	relay := s.relay
+	s.mu.Unlock()
+	if relay == nil {
+		writeError(w, http.StatusNotFound, errors.New("no validator is running"))
+		return
+	}
+	relay.Deliver(msg)
+	writeJSON(w, http.StatusOK, map[string]bool{"delivered": true})
+}
@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+// bftParams returns params of a BFT chain of n validators, sorted as
+// Validators sorts them.
+func bftParams(n int) ([]*Wallet, Params) {
+	p := params
+	p.Consensus, p.BlockPeriod = "bft", 0
+	wallets, p := testValidators(n, p)
+	sort.Slice(wallets, func(i, j int) bool { return wallets[i].Address() < wallets[j].Address() })
+	return wallets, p
+}
+
+func signedBy(commit *Commit, w *Wallet) bool {
+	return slices.ContainsFunc(commit.Signatures, func(s CommitSig) bool { return bytes.Equal(s.PubKey, w.PubKey()) })
+}
+
+func TestBFT(t *testing.T) {
+	const blocks = 6
+	wallets, p := bftParams(4)
+	bc := testChain(t, p)
+	validators := Validators()
+	offline := wallets[0]
+	u := make(UTXOSet)
+	for range blocks {
+		height := bc.Height() + 1
+		templates := make(map[string]*Block)
+		valid := make(map[string]bool)
+		for _, w := range wallets {
+			block := bc.BlockTemplate(w.LockScript())
+			if err := params.Engine().Prepare(bc, block, &Miner{Signer: w, Relay: NewBFTRelay(nil)}); err != nil {
+				t.Fatal(err)
+			}
+			templates[w.Address()] = block
+			valid[fmt.Sprintf("%x", block.Header.Hash)] = VerifyProposal(bc, &u, block) == nil
+		}
+		network := NewBFTNetwork()
+		network.Offline[offline.Address()] = true
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		block, err := RunBFT(ctx, network, wallets, height,
+			func(w *Wallet) *Block { return templates[w.Address()] },
+			func(b *Block) bool { return valid[fmt.Sprintf("%x", b.Header.Hash)] })
+		cancel()
+		if err != nil {
+			t.Fatalf("block %v is not decided: %v", height, err)
+		}
+		commit, err := DecodeCommit(block.Seal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := commit.Verify(&block.Header, validators); err != nil {
+			t.Errorf("block %v: %v", height, err)
+		}
+		if signedBy(commit, offline) {
+			t.Errorf("block %v is committed by offline validator", height)
+		}
+		proposer := Proposer(validators, height, commit.Round)
+		if proposer == offline.Address() {
+			t.Errorf("block %v is proposed by offline validator", height)
+		}
+		if Proposer(validators, height, 0) == offline.Address() && commit.Round == 0 {
+			t.Errorf("block %v is committed in round of offline proposer", height)
+		}
+		if bytes.Equal(block.Header.Hash, templates[offline.Address()].Header.Hash) {
+			t.Errorf("block %v of offline validator is committed", height)
+		}
+
+		forged := *block
+		forged.Seal = (&Commit{commit.Round, commit.Signatures[:Quorum(len(validators))-1]}).Bytes()
+		if err := params.Engine().VerifyHeader(bc, &forged); err == nil {
+			t.Errorf("block %v is accepted without quorum", height)
+		}
+		forged.Seal = (&Commit{commit.Round + 1, commit.Signatures}).Bytes()
+		if err := params.Engine().VerifyHeader(bc, &forged); err == nil {
+			t.Errorf("block %v is accepted with commit of another round", height)
+		}
+		if err := bc.SubmitBlock(block, &u); err != nil {
+			t.Fatalf("block %v: %v", height, err)
+		}
+	}
+	if bc.Height() != blocks-1 || !bc.Verify() {
+		t.Error("chain does not verify")
+	}
+}
+
+func TestBFTRelay(t *testing.T) {
+	wallets, p := bftParams(4)
+	bc := testChain(t, p)
+	urls := make([]string, len(wallets))
+	relays := make([]*BFTRelay, len(wallets))
+	for i := range wallets {
+		s := NewServer()
+		s.relay = NewBFTRelay(func() []string {
+			return slices.Concat(urls[:i], urls[i+1:])
+		})
+		relays[i] = s.relay
+		server := httptest.NewServer(s.mux)
+		defer server.Close()
+		urls[i] = server.URL
+	}
+	proposed := bc.BlockTemplate(wallets[0].LockScript())
+	proposed.Header.Version = sealedBlockVersion
+	proposed.Header.Hash = proposed.Header.ComputeHash()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	type result struct {
+		block *Block
+		err   error
+	}
+	results := make(chan result, len(wallets))
+	for i, w := range wallets {
+		inbox := relays[i].Join(proposed.Header.Height)
+		node := &BFTNode{
+			Wallet:     w,
+			Validators: Validators(),
+			Height:     proposed.Header.Height,
+			Network:    relays[i],
+			Propose:    func() *Block { return proposed },
+			Valid:      func(b *Block) bool { return bytes.Equal(b.Header.Hash, proposed.Header.Hash) },
+		}
+		go func() {
+			block, err := node.Run(ctx, inbox)
+			results <- result{block, err}
+		}()
+	}
+	for range wallets {
+		r := <-results
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if !bytes.Equal(r.block.Header.Hash, proposed.Header.Hash) {
+			t.Fatalf("decided block %x, want %x", r.block.Header.Hash, proposed.Header.Hash)
+		}
+		commit, err := DecodeCommit(r.block.Seal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := commit.Verify(&r.block.Header, Validators()); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := bc.SubmitBlock(proposed, &UTXOSet{}); err == nil {
+		t.Error("block without commit is accepted")
+	}
+}
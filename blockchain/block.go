@@ -2,120 +2,196 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
+	"context"
 	"crypto/sha256"
-	"encoding/gob"
+	"errors"
 	"fmt"
-	"math"
-	"math/big"
-	"reflect"
 	"time"
 )
 
+const (
+	blockVersion = 1
+	// sealedBlockVersion blocks carry a seal of consensus engine after
+	// transactions, which is not covered by block hash.
+	sealedBlockVersion = 2
+)
+
 type Block struct {
 	Header BlockHeader
 	Txs    Txs
+	Seal   []byte
 }
 
 func (b *Block) Bytes() []byte {
-	return append(
-		b.Header.Bytes(),
-		b.Txs.Bytes()...,
-	)
+	e := &Encoder{}
+	e.Write(b.Header.Bytes())
+	e.Write(b.Txs.Bytes())
+	if b.Header.Version == sealedBlockVersion {
+		e.WriteBytes(b.Seal)
+	}
+	return e.Bytes()
 }
 
 func (b *Block) Hash() []byte {
-	hash := sha256.Sum256(b.Bytes())
-	return hash[:]
+	return b.Header.ComputeHash()
 }
 
 func (b *Block) Mine(difficulty int) *Block {
-	var hash []byte
-	var hashInt big.Int
-	target := big.NewInt(1)
-	target = target.Lsh(target, uint(256-difficulty))
 	fmt.Println("Mining a New Block")
-	for b.Header.Nonce < math.MaxInt {
-		hash = b.Hash()
-		hashInt.SetBytes(hash)
-		fmt.Printf("\r%x", hash)
-		if hashInt.Cmp(target) == -1 {
-			break
-		} else {
-			b.Header.Nonce++
-		}
+	block, err := NewMiner(0).Mine(context.Background(), b, difficulty)
+	if err != nil {
+		panic(err)
 	}
-	b.Header.Hash = hash
-	fmt.Println()
-	return b
+	fmt.Printf("%x\n", block.Header.Hash)
+	return block
+}
+
+// Verify checks the block on top of UTXO set u of its parent.
+func (b *Block) Verify(bc *Blockchain, u *UTXOSet) bool {
+	return bytes.Equal(b.Header.Hash, b.Hash()) && params.Engine().VerifyHeader(bc, b) == nil && b.VerifyBody(u)
 }
 
-func (b *Block) Verify() bool {
-	result := true
-	bc := *b
-	bc.Header.Hash = nil
-	result = result && reflect.DeepEqual(
-		[]byte(b.Header.Hash),
-		bc.Hash(),
-	)
-	for _, tx := range b.Txs {
-		for _, in := range tx.TxIn {
-			pubKey := &ecdsa.PublicKey{
-				Curve: elliptic.P256(),
-				X:     big.NewInt(0).SetBytes(in.PubKey[:32]),
-				Y:     big.NewInt(0).SetBytes(in.PubKey[32:]),
+// VerifyBody checks transactions of the block against UTXO set u of its
+// parent and their commitment in the header, but not the header itself.
+func (b *Block) VerifyBody(u *UTXOSet) bool {
+	result := b.CheckSpends(u) == nil
+	result = result && bytes.Equal(b.Header.MerkleRoot, b.Txs.MerkleRoot())
+	result = result && len(b.Txs) > 0 && b.Txs[0].IsCoinBase()
+	if result {
+		height, ok := b.Txs[0].CoinBaseHeight()
+		result = ok && height == b.Header.Height
+	}
+	fees := Amount(0)
+	for i, tx := range b.Txs {
+		outValue, err := tx.CheckAmounts()
+		result = result && err == nil
+		result = result && tx.Final(b.Header.Height, b.Header.Timestamp)
+		if tx.IsCoinBase() {
+			result = result && i == 0
+			continue
+		}
+		inValue := Amount(0)
+		for idx, txIn := range tx.TxIn {
+			var prevOut *TxOut
+			if utxo := u.UTXO(txIn.TxOutHash, txIn.TxOutIndex); utxo != nil {
+				prevOut = utxo.TxOut
+				result = result && utxo.Mature(b.Header.Height)
+			} else if prevOut = b.Txs.TxOut(txIn.TxOutHash, txIn.TxOutIndex); prevOut != nil {
+				result = result && !b.Txs.TxByID(txIn.TxOutHash).IsCoinBase()
+			}
+			result = result && prevOut != nil && tx.VerifyInput(idx, prevOut)
+			if prevOut != nil {
+				inValue, err = SumAmounts(inValue, prevOut.Value)
+				result = result && err == nil
 			}
-			result = result && ecdsa.VerifyASN1(
-				pubKey, tx.Trim().Hash(), in.Signature,
-			)
 		}
+		result = result && inValue >= outValue
+		fees, err = SumAmounts(fees, inValue-outValue)
+		result = result && err == nil
+	}
+	if result {
+		result = b.Txs[0].OutputValue() <= params.Subsidy(b.Header.Height)+fees
 	}
 	return result
 }
 
 func (b *Block) Serialize() []byte {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(b)
-	if err != nil {
-		panic(err)
-	}
-	return buf.Bytes()
+	return b.Bytes()
 }
 
 func BlockDeserialize(data []byte) *Block {
-	b := &Block{}
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
-	err := dec.Decode(b)
+	b, err := DecodeBlock(data)
 	if err != nil {
 		panic(err)
 	}
 	return b
 }
 
+func DecodeBlock(data []byte) (*Block, error) {
+	d := NewDecoder(data)
+	b := &Block{}
+	b.Header = d.ReadBlockHeader()
+	b.Txs = d.ReadTxs()
+	if b.Header.Version == sealedBlockVersion {
+		b.Seal = d.ReadBytes()
+	}
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if d.Remaining() != 0 {
+		return nil, errors.New("trailing data after block")
+	}
+	return b, nil
+}
+
 type BlockHeader struct {
-	Timestamp int
-	Nonce     int
-	Hash      []byte
-	PrevHash  []byte
+	Version    int
+	Timestamp  int
+	Nonce      int
+	Height     int
+	Hash       []byte
+	PrevHash   []byte
+	MerkleRoot []byte
 }
 
-func NewBlockHeader(prevHash []byte) BlockHeader {
+func NewBlockHeader(prevHash []byte, height int, txs Txs) BlockHeader {
 	return BlockHeader{
+		blockVersion,
 		int(time.Now().Unix()),
 		0,
+		height,
 		nil,
 		prevHash,
+		txs.MerkleRoot(),
 	}
 }
 
 func (h *BlockHeader) Bytes() []byte {
-	return bytes.Join([][]byte{
-		IntToBytes(h.Timestamp),
-		IntToBytes(h.Nonce),
-		h.Hash,
-		h.PrevHash,
-	}, nil)
+	e := &Encoder{}
+	e.WriteUint32(uint32(h.Version))
+	e.WriteHash(h.PrevHash)
+	e.WriteHash(h.MerkleRoot)
+	e.WriteInt64(int64(h.Timestamp))
+	e.WriteUint32(uint32(h.Height))
+	e.WriteUint64(uint64(h.Nonce))
+	return e.Bytes()
+}
+
+func (h *BlockHeader) ComputeHash() []byte {
+	hash := sha256.Sum256(h.Bytes())
+	return hash[:]
+}
+
+// PoWHash is compared with target, while block is identified by its SHA256 hash.
+func (h *BlockHeader) PoWHash() []byte {
+	return params.ProofOfWork().Hash(h.Bytes())
+}
+
+func DecodeBlockHeader(data []byte) (*BlockHeader, error) {
+	d := NewDecoder(data)
+	h := d.ReadBlockHeader()
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if d.Remaining() != 0 {
+		return nil, errors.New("trailing data after block header")
+	}
+	return &h, nil
+}
+
+func (d *Decoder) ReadBlockHeader() BlockHeader {
+	h := BlockHeader{}
+	h.Version = int(d.ReadUint32())
+	if d.Err() == nil && h.Version != blockVersion && h.Version != sealedBlockVersion {
+		d.fail(errors.New("unsupported block version"))
+	}
+	h.PrevHash = d.ReadHash()
+	h.MerkleRoot = d.ReadHash()
+	h.Timestamp = int(d.ReadInt64())
+	h.Height = int(d.ReadUint32())
+	h.Nonce = int(d.ReadUint64())
+	if d.Err() == nil {
+		h.Hash = h.ComputeHash()
+	}
+	return h
 }
@@ -0,0 +1,65 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+)
+
+// sealBlock mines a block of txs on top of the tip, without validating it.
+func sealBlock(t *testing.T, bc *Blockchain, w *Wallet, txs ...*Tx) *Block {
+	height := bc.Height() + 1
+	txs = append(Txs{CoinBaseTx([]*Payment{{w.LockScript(), params.Subsidy(height)}}, height)}, txs...)
+	block := &Block{NewBlockHeader(bc.LastHash(), height, txs), txs, nil}
+	block, err := params.Engine().Seal(context.Background(), block, NewMiner(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestVerifyDoubleSpend(t *testing.T) {
+	p := params
+	p.Consensus, p.CoinbaseMaturity = "pow", 1
+	bc := testChain(t, p)
+	alice, bob := newTestWallet(), newTestWallet()
+	u := make(UTXOSet)
+	for range 2 {
+		if _, err := bc.Mine(context.Background(), alice, &u, NewMiner(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx, _, err := TransferTx(alice, bob.LockScript(), Coin, 0, bc.Height()+1, &u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Submit(tx, &u); err != nil {
+		t.Fatal(err)
+	}
+	spend, _, err := TransferTx(bob, alice.LockScript(), Coin/2, 0, bc.Height()+1, bc.Mempool.View(&u), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sealBlock(t, bc, alice, spend, tx).CheckSpends(&u); err == nil {
+		t.Error("block spends output of later transaction")
+	}
+	if err := bc.SubmitBlock(sealBlock(t, bc, alice, tx, spend), &u); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.Verify() {
+		t.Fatal("chain does not verify")
+	}
+
+	doubleSpend := &Tx{TxOut: []*TxOut{{Coin / 2, bob.LockScript()}}}
+	for _, in := range spend.TxIn {
+		doubleSpend.TxIn = append(doubleSpend.TxIn, &TxIn{in.TxOutHash, in.TxOutIndex, nil, SequenceFinal})
+	}
+	doubleSpend.Sign(bob)
+	block := sealBlock(t, bc, alice, doubleSpend)
+	if err := bc.SubmitBlock(block, &u); err == nil {
+		t.Fatal("block spending spent output is accepted")
+	}
+	bc.DB.AddBlock(block)
+	if bc.Verify() {
+		t.Error("chain with block spending spent output verifies")
+	}
+}
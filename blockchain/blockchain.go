@@ -2,78 +2,360 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
+	"time"
 )
 
 const (
 	difficulty = 16
-	reward     = 10
 )
 
+// errStaleBlock rejects a block which does not extend the tip, which is not
+// invalid by itself, since the tip may have moved after it was mined.
+var errStaleBlock = errors.New("block does not extend the tip")
+
 type Blockchain struct {
 	DB         *Database `json:"-"`
-	Pool       Txs
+	Mempool    *Mempool
 	Difficulty int
 	Valid      bool
 }
 
-func (bc Blockchain) Send(from, to *Wallet, amount int, u *UTXOSet) {
-	tx := TransferTx(from, to, amount, u)
-	bc.Pool = append(Txs{tx}, bc.Pool...)
-	u.Update(tx)
-	bc.DB.SetPool(&bc.Pool)
-	bc.DB.SetUTXOSet(u)
+func (bc *Blockchain) Send(from *Wallet, to Script, amount, feeRate Amount, u *UTXOSet, cc *CoinControl) (*Tx, Amount, error) {
+	tx, fee, err := TransferTx(from, to, amount, feeRate, bc.Height()+1, bc.Mempool.View(u), cc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tx, fee, bc.submitPayment(tx, 1, u)
+}
+
+func (bc *Blockchain) SendMany(from *Wallet, payments []*Payment, feeRate Amount, u *UTXOSet, cc *CoinControl) (*Tx, Amount, error) {
+	tx, fee, err := PaymentTx(from, payments, feeRate, bc.Height()+1, bc.Mempool.View(u), cc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tx, fee, bc.submitPayment(tx, len(payments), u)
+}
+
+// submitPayment submits a transaction of PaymentTx and records its change
+// output, which PaymentTx appends after payments.
+func (bc *Blockchain) submitPayment(tx *Tx, payments int, u *UTXOSet) error {
+	if err := bc.Submit(tx, u); err != nil {
+		return err
+	}
+	if len(tx.TxOut) > payments {
+		bc.recordChange(tx, payments)
+	}
+	return nil
+}
+
+func (bc *Blockchain) recordChange(tx *Tx, idx int) {
+	c := bc.DB.ChangeOutputs()
+	if c == nil {
+		c = new(ChangeOutputs)
+		*c = make(ChangeOutputs)
+	}
+	(*c)[fmt.Sprintf("%x", tx.ID())] = idx
+	bc.DB.SetChangeOutputs(c)
+}
+
+// BumpFee replaces a mempool transaction of wallet with the one spending the
+// same inputs, paying the higher fee from the change output recorded when the
+// transaction was sent.
+func (bc *Blockchain) BumpFee(from *Wallet, txid string, u *UTXOSet) (*Tx, error) {
+	e := bc.Mempool.Entries[txid]
+	if e == nil {
+		return nil, errors.New("transaction is not in mempool")
+	}
+	if !e.Tx.SignalsRBF() {
+		return nil, errors.New("transaction is not replaceable")
+	}
+	change, ok := -1, false
+	if c := bc.DB.ChangeOutputs(); c != nil {
+		change, ok = (*c)[txid]
+	}
+	if !ok || change >= len(e.Tx.TxOut) || !e.Tx.TxOut[change].LockedWith(from.LockScript()) {
+		return nil, errors.New("transaction has no change output")
+	}
+	tx := &Tx{nil, nil, e.Tx.LockTime}
+	for _, in := range e.Tx.TxIn {
+		tx.TxIn = append(tx.TxIn, &TxIn{in.TxOutHash, in.TxOutIndex, nil, in.Sequence})
+	}
+	for _, out := range e.Tx.TxOut {
+		tx.TxOut = append(tx.TxOut, &TxOut{out.Value, out.Script})
+	}
+	fee := e.Fee
+	for size := e.Size; fee < bc.Mempool.ReplacementFee(txid, size); size = len(tx.Bytes()) {
+		fee = bc.Mempool.ReplacementFee(txid, size)
+		tx.TxOut[change].Value = e.Tx.TxOut[change].Value - (fee - e.Fee)
+		if tx.TxOut[change].Value < DustThreshold {
+			return nil, errors.New("change output is too small to pay fee")
+		}
+		tx.Sign(from)
+	}
+	if err := bc.Submit(tx, u); err != nil {
+		return nil, err
+	}
+	bc.recordChange(tx, change)
+	return tx, nil
 }
 
-func (bc *Blockchain) Mine(miner *Wallet, u *UTXOSet) {
-	tx := CoinBaseTx(miner)
-	bc.Pool = append(Txs{tx}, bc.Pool...)
-	u.Update(tx)
-	lastHash := bc.LastHash()
-	header := NewBlockHeader(lastHash)
-	txs := bc.Pool
-	bc.Pool = nil
-	block := &Block{header, txs}
-	block = block.Mine(bc.Difficulty)
+// expireMempool removes mempool entries older than MempoolExpiry. It runs
+// when transactions enter mempool and when blocks are connected, so that
+// reading the chain does not change it.
+func (bc *Blockchain) expireMempool(fs *FeeStats) {
+	entries := maps.Clone(bc.Mempool.Entries)
+	bc.removeUnconfirmed(fs, entries, bc.Mempool.Expire(int(time.Now().Unix())))
+}
+
+// removeUnconfirmed removes entries of txids, which have left mempool without
+// being confirmed, from database and records them as failures in fee stats.
+func (bc *Blockchain) removeUnconfirmed(fs *FeeStats, entries map[string]*MempoolEntry, txids []string) {
+	failed := make([]*MempoolEntry, 0, len(txids))
+	for _, txid := range txids {
+		if e, ok := entries[txid]; ok {
+			failed = append(failed, e)
+		}
+	}
+	fs.ProcessFailed(bc.Height(), failed)
+	bc.DB.RemoveMempoolEntries(txids)
+}
+
+func (bc *Blockchain) Submit(tx *Tx, u *UTXOSet) error {
+	fs := bc.FeeStats()
+	defer bc.DB.SetFeeStats(fs)
+	bc.expireMempool(fs)
+	entries := maps.Clone(bc.Mempool.Entries)
+	e, evicted, err := bc.Mempool.Add(tx, u, bc.Height()+1)
+	bc.removeUnconfirmed(fs, entries, evicted)
+	if err != nil {
+		return err
+	}
+	bc.DB.AddMempoolEntry(e)
+	return nil
+}
+
+// Mine seals a block which pays to miner, who also signs it for engines
+// which require a signer.
+func (bc *Blockchain) Mine(ctx context.Context, miner *Wallet, u *UTXOSet, m *Miner) (*Block, error) {
+	engine := params.Engine()
+	block := bc.BlockTemplate(miner.LockScript())
+	m.Signer = miner
+	if err := engine.Prepare(bc, block, m); err != nil {
+		return nil, err
+	}
+	block, err := engine.Seal(ctx, block, m)
+	if err != nil {
+		return nil, err
+	}
+	return block, bc.SubmitBlock(block, u)
+}
+
+// BlockTemplate builds the next block from mempool, which pays subsidy and
+// fees to lock. Block is not mined yet.
+func (bc *Blockchain) BlockTemplate(lock Script) *Block {
+	return bc.PayoutBlockTemplate(func(reward Amount) []*Payment {
+		return []*Payment{{lock, reward}}
+	})
+}
+
+// PayoutBlockTemplate splits subsidy and fees of the block among coinbase
+// outputs returned by payouts.
+func (bc *Blockchain) PayoutBlockTemplate(payouts func(reward Amount) []*Payment) *Block {
+	lastHash, height := bc.LastHash(), bc.Height()+1
+	pool := bc.Mempool.BlockTxs()
+	fees := Amount(0)
+	for _, tx := range pool {
+		fees += bc.Mempool.Entries[fmt.Sprintf("%x", tx.ID())].Fee
+	}
+	txs := append(Txs{CoinBaseTx(payouts(params.Subsidy(height)+fees), height)}, pool...)
+	return &Block{NewBlockHeader(lastHash, height, txs), txs, nil}
+}
+
+// CheckSpends checks that inputs of block spend outputs of UTXO set or of
+// earlier transactions of the block, each at most once.
+func (b *Block) CheckSpends(u *UTXOSet) error {
+	spent := make(map[string]bool)
+	earlier := make(map[string]*Tx)
+	for i, tx := range b.Txs {
+		for _, in := range tx.TxIn {
+			if i == 0 {
+				break
+			}
+			op := outpoint(in.TxOutHash, in.TxOutIndex)
+			prevTx := earlier[fmt.Sprintf("%x", in.TxOutHash)]
+			inBlock := prevTx != nil && in.TxOutIndex >= 0 && in.TxOutIndex < len(prevTx.TxOut)
+			if spent[op] || u.UTXO(in.TxOutHash, in.TxOutIndex) == nil && !inBlock {
+				return fmt.Errorf("block spends unknown or spent output %v", op)
+			}
+			spent[op] = true
+		}
+		earlier[fmt.Sprintf("%x", tx.ID())] = tx
+	}
+	return nil
+}
+
+// SubmitBlock validates a mined block on top of the tip and adds it to
+// blockchain, updating UTXO set, mempool and fee statistics.
+func (bc *Blockchain) SubmitBlock(block *Block, u *UTXOSet) error {
+	height := bc.Height() + 1
+	if !bytes.Equal(block.Header.PrevHash, bc.LastHash()) || block.Header.Height != height {
+		if r, ok := params.Engine().(EvidenceReporter); ok && r.Report(bc, block) == nil {
+			return fmt.Errorf("%w, double signing is reported", errStaleBlock)
+		}
+		return errStaleBlock
+	}
+	if err := block.CheckSpends(u); err != nil {
+		return err
+	}
+	if !block.Verify(bc, u) {
+		return errors.New("block is invalid")
+	}
+	pool := maps.Clone(bc.Mempool.Entries)
+	entries := make([]*MempoolEntry, 0, len(block.Txs))
+	for _, tx := range block.Txs {
+		txid := fmt.Sprintf("%x", tx.ID())
+		if e, ok := pool[txid]; ok {
+			entries = append(entries, e)
+			delete(pool, txid)
+		}
+	}
 	bc.DB.AddBlock(block)
-	bc.DB.SetPool(&bc.Pool)
+	u.Apply(block.Txs, height)
+	fs := bc.FeeStats()
+	fs.ProcessBlock(height, entries)
+	bc.removeUnconfirmed(fs, pool, bc.Mempool.RemoveForBlock(block.Txs))
+	bc.expireMempool(fs)
+	bc.DB.SetUTXOSet(u)
+	bc.DB.SetFeeStats(fs)
+	return nil
+}
+
+// SetTip makes block with hash the tip of chain, which may be a block of
+// another branch kept in database, and rebuilds UTXO set. Transactions of
+// blocks which leave the chain return to mempool, and mempool transactions
+// which no longer fit the chain are removed.
+func (bc *Blockchain) SetTip(hash []byte, u *UTXOSet) {
+	branch := make(map[string]bool)
+	for block := bc.DB.Block(hash); block != nil; block = bc.DB.Block(block.Header.PrevHash) {
+		branch[fmt.Sprintf("%x", block.Header.Hash)] = true
+	}
+	var txs Txs
+	bc.DB.BlockchainTip()
+	for block := bc.DB.NextBlock(); block != nil && !branch[fmt.Sprintf("%x", block.Header.Hash)]; block = bc.DB.NextBlock() {
+		txs = slices.Concat(block.Txs[1:], txs)
+	}
+	txs = append(txs, bc.Mempool.Txs()...)
+	bc.DB.RemoveMempoolEntries(slices.Collect(maps.Keys(bc.Mempool.Entries)))
+	bc.Mempool = NewMempool()
+	bc.DB.SetTip(hash)
+	u.Index(bc)
 	bc.DB.SetUTXOSet(u)
+	for _, tx := range txs {
+		bc.Submit(tx, u)
+	}
+}
+
+func (bc *Blockchain) FeeStats() *FeeStats {
+	fs := bc.DB.FeeStats()
+	if fs == nil {
+		fs = NewFeeStats()
+	}
+	return fs
+}
+
+// EstimateFee returns the fee rate per 1000 bytes which is likely to confirm
+// a transaction within target blocks.
+func (bc *Blockchain) EstimateFee(target int) (Amount, error) {
+	return bc.FeeStats().Estimate(target, bc.Height(), slices.Collect(maps.Values(bc.Mempool.Entries)))
+}
+
+// FeeRate parses fee rate given by user, or estimates the one which confirms
+// within 6 blocks when it is empty. Estimate is never below the minimum relay fee.
+func (bc *Blockchain) FeeRate(s string) (Amount, error) {
+	if s != "" {
+		return ParseAmount(s)
+	}
+	if estimate, err := bc.EstimateFee(6); err == nil {
+		return max(estimate, params.MinRelayFee), nil
+	}
+	return params.MinRelayFee, nil
+}
+
+func (bc *Blockchain) Fee(tx *Tx) Amount {
+	inValue := Amount(0)
+	for _, in := range tx.TxIn {
+		if prevOut := bc.TxOut(in.TxOutHash, in.TxOutIndex); prevOut != nil {
+			inValue += prevOut.Value
+		}
+	}
+	return inValue - tx.OutputValue()
+}
+
+// Supply returns issued coins, which are the value of all unspent outputs,
+// since fees only move coins to the coinbase.
+func (bc *Blockchain) Supply() Amount {
+	supply := Amount(0)
+	for _, outs := range bc.UnspentTxOuts() {
+		for _, utxo := range outs {
+			supply += utxo.Value
+		}
+	}
+	return supply
 }
 
 func (bc *Blockchain) TxByHash(txHash []byte) *Tx {
+	tx, _ := bc.FindTx(txHash)
+	return tx
+}
+
+func (bc *Blockchain) FindTx(txHash []byte) (*Tx, int) {
+	key := bc.DB.Key
+	defer func() { bc.DB.Key = key }()
 	bc.DB.BlockchainTip()
 	block := bc.DB.NextBlock()
 	for block != nil {
 		for _, tx := range block.Txs {
-			if reflect.DeepEqual(tx.Hash(), txHash) {
-				return tx
+			if reflect.DeepEqual(tx.ID(), txHash) {
+				return tx, block.Header.Height
 			}
 		}
 		block = bc.DB.NextBlock()
 	}
-	return nil
+	return nil, -1
+}
+
+func (bc *Blockchain) TxOut(txHash []byte, idx int) *TxOut {
+	tx := bc.TxByHash(txHash)
+	if tx == nil || idx < 0 || idx >= len(tx.TxOut) {
+		return nil
+	}
+	return tx.TxOut[idx]
 }
 
+// Verify checks every block from the first one on top of UTXO set of its
+// parent, which is built block by block as Index does.
 func (bc *Blockchain) Verify() bool {
-	result := true
+	var hashes [][]byte
 	bc.DB.BlockchainTip()
-	block := bc.DB.NextBlock()
-	for block != nil {
-		result = result && block.Verify()
-		if next := bc.DB.PeekBlock(); next != nil {
-			result = result &&
-				reflect.DeepEqual(
-					block.Header.PrevHash,
-					next.Header.Hash,
-				)
-		}
-		if !result {
-			break
-		}
-		block = bc.DB.NextBlock()
+	for block := bc.DB.NextBlock(); block != nil; block = bc.DB.NextBlock() {
+		hashes = append(hashes, block.Header.Hash)
+	}
+	result := true
+	u := make(UTXOSet)
+	var prevHash []byte
+	for i := len(hashes) - 1; i >= 0 && result; i-- {
+		block := bc.DB.Block(hashes[i])
+		result = bytes.Equal(block.Header.PrevHash, prevHash) && block.Verify(bc, &u)
+		u.Apply(block.Txs, block.Header.Height)
+		prevHash = block.Header.Hash
 	}
 	bc.Valid = result
 	return result
@@ -89,13 +371,22 @@ func (bc *Blockchain) LastHash() []byte {
 	return lastHash
 }
 
-func (bc *Blockchain) UnspentTxOuts() map[string][]*TxOut {
+func (bc *Blockchain) Height() int {
+	bc.DB.BlockchainTip()
+	block := bc.DB.NextBlock()
+	if block == nil {
+		return -1
+	}
+	return block.Header.Height
+}
+
+func (bc *Blockchain) UnspentTxOuts() map[string]map[int]*UTXO {
 	spent := make(map[string][]int)
-	unspent := bc.Pool.UnspentTxOuts(spent)
+	unspent := make(map[string]map[int]*UTXO)
 	bc.DB.BlockchainTip()
 	block := bc.DB.NextBlock()
 	for block != nil {
-		unspentBlock := block.Txs.UnspentTxOuts(spent)
+		unspentBlock := block.Txs.UnspentTxOuts(spent, block.Header.Height)
 		for k, v := range unspentBlock {
 			unspent[k] = v
 		}
@@ -1,8 +1,22 @@
 package blockchain
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func Wallet_(args []string) {
@@ -10,9 +24,17 @@ func Wallet_(args []string) {
 		fmt.Printf(
 			"Usage:  blockchain wallet command args...\n\t" +
 				"balance holder - get balance of holder wallet\n\t" +
+				"bumpfee txid - replace mempool transaction with a higher fee one\n\t" +
+				"cosign id holder - sign a multisignature transaction\n\t" +
 				"create - create a new wallet\n\t" +
+				"createmultisig m key... - create a multisignature address\n\t" +
 				"delete holder - delete wallet of holder\n\t" +
-				"list - list all wallets\n",
+				"list - list all wallets\n\t" +
+				"listunspent holder - list unspent outputs of holder\n\t" +
+				"lock hash:index - exclude output from automatic coin selection\n\t" +
+				"migrate - store wallets under their current address and list legacy addresses\n\t" +
+				"pubkey holder - get public key of holder wallet\n\t" +
+				"unlock hash:index - allow output in automatic coin selection\n",
 		)
 		return
 	}
@@ -24,12 +46,21 @@ func Wallet_(args []string) {
 		ws = new(Wallets)
 		*ws = make(Wallets)
 	}
+	mss := db.Multisigs()
+	if mss == nil {
+		mss = new(Multisigs)
+		*mss = make(Multisigs)
+	}
 	switch method {
 	case "balance":
 		holder := args[1]
 		bc := db.Blockchain()
-		wallet := ws.Wallet(holder)
-		if wallet == nil {
+		var lock Script
+		if wallet := ws.Wallet(holder); wallet != nil {
+			lock = wallet.LockScript()
+		} else if ms := mss.Multisig(holder); ms != nil {
+			lock = ms.LockScript()
+		} else {
 			fmt.Println("Cli.Wallet: Failed to Get Wallet: Wallet does not exist")
 			return
 		}
@@ -40,12 +71,138 @@ func Wallet_(args []string) {
 			u.Index(bc)
 			db.SetUTXOSet(u)
 		}
-		utxo := u.UnspentTxOuts(wallet)
-		balance := 0
+		utxo := bc.Mempool.View(u).UnspentTxOuts(lock)
+		height := bc.Height() + 1
+		balance, immature := Amount(0), Amount(0)
 		for _, out := range utxo {
-			balance += out.Value
+			if out.Mature(height) {
+				balance += out.Value
+			} else {
+				immature += out.Value
+			}
+		}
+		fmt.Printf("Balance of %v: %v\n", holder, balance)
+		if immature > 0 {
+			fmt.Printf("Immature: %v\n", immature)
+		}
+	case "bumpfee":
+		if len(args) < 2 {
+			fmt.Println("Usage: blockchain wallet bumpfee txid")
+			return
+		}
+		bc := db.Blockchain()
+		e := bc.Mempool.Entries[args[1]]
+		if e == nil {
+			fmt.Println("Cli.Wallet: Failed to Get Transaction: Transaction is not in mempool")
+			return
+		}
+		u := db.UTXOSet()
+		if u == nil {
+			u = new(UTXOSet)
+			*u = make(UTXOSet)
+			u.Index(bc)
+			db.SetUTXOSet(u)
+		}
+		var wallet *Wallet
+		prevOut, err := bc.Mempool.prevOut(e.Tx.TxIn[0], u, bc.Height()+1)
+		for _, w := range *ws {
+			if err == nil && prevOut.LockedWith(w.LockScript()) {
+				wallet = w
+			}
+		}
+		if wallet == nil {
+			fmt.Println("Cli.Wallet: Failed to Get Wallet: Transaction is not spent by local wallet")
+			return
+		}
+		tx, err := bc.BumpFee(wallet, args[1], u)
+		if err != nil {
+			fmt.Printf("Cli.Wallet: Failed to Bump Fee: %v\n", err)
+			return
+		}
+		fmt.Printf("%x\n", tx.ID())
+		fmt.Printf("Fee: %v -> %v\n", e.Fee, bc.Mempool.Entries[fmt.Sprintf("%x", tx.ID())].Fee)
+	case "cosign":
+		if len(args) < 3 {
+			fmt.Println("Usage: blockchain wallet cosign id holder")
+			return
+		}
+		ps := db.PartialTxs()
+		if ps == nil {
+			ps = new(PartialTxs)
+			*ps = make(PartialTxs)
+		}
+		ptx := (*ps)[args[1]]
+		if ptx == nil {
+			fmt.Println("Cli.Wallet: Failed to Get Transaction: Transaction does not exist")
+			return
+		}
+		wallet := ws.Wallet(args[2])
+		if wallet == nil {
+			fmt.Println("Cli.Wallet: Failed to Get Wallet: Wallet does not exist")
+			return
+		}
+		if signed, err := ptx.Sign(wallet, SigHashAll); err != nil {
+			fmt.Printf("Cli.Wallet: Failed to Sign Transaction: %v\n", err)
+			return
+		} else if signed == 0 {
+			fmt.Println("Cli.Wallet: Failed to Sign Transaction: Wallet is not a cosigner")
+			return
+		}
+		if !ptx.Complete() {
+			db.SetPartialTxs(ps)
+			fmt.Printf("Signed %v, waiting for more signatures\n", args[1])
+			return
+		}
+		tx, err := ptx.Finalize()
+		if err != nil {
+			fmt.Printf("Cli.Wallet: Failed to Finalize Transaction: %v\n", err)
+			return
+		}
+		bc := db.Blockchain()
+		u := db.UTXOSet()
+		if u == nil {
+			u = new(UTXOSet)
+			*u = make(UTXOSet)
+			u.Index(bc)
+		}
+		if err := bc.Submit(tx, u); err != nil {
+			fmt.Printf("Cli.Wallet: Failed to Submit Transaction: %v\n", err)
+			return
+		}
+		delete(*ps, args[1])
+		db.SetPartialTxs(ps)
+		fmt.Printf("Signed %v, transaction submitted to pool\n", args[1])
+	case "createmultisig":
+		if len(args) < 3 {
+			fmt.Println("Usage: blockchain wallet createmultisig m key...")
+			return
+		}
+		m, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("Cli.Wallet: Failed to Create Multisig: Invalid Number of Signatures")
+			return
+		}
+		pubKeys := make([][]byte, 0)
+		for _, key := range args[2:] {
+			if wallet := ws.Wallet(key); wallet != nil {
+				pubKeys = append(pubKeys, wallet.PubKey())
+				continue
+			}
+			pubKey, err := hex.DecodeString(key)
+			if err != nil {
+				fmt.Printf("Cli.Wallet: Failed to Create Multisig: Invalid Key %v\n", key)
+				return
+			}
+			pubKeys = append(pubKeys, pubKey)
 		}
-		fmt.Printf("Balance of %v: %v\n", wallet.Address(), balance)
+		ms, err := NewMultisig(m, pubKeys)
+		if err != nil {
+			fmt.Printf("Cli.Wallet: Failed to Create Multisig: %v\n", err)
+			return
+		}
+		mss.Add(ms)
+		db.SetMultisigs(mss)
+		fmt.Println(ms.Address())
 	case "create":
 		wallet := ws.NewWallet()
 		db.SetWallets(ws)
@@ -56,42 +213,137 @@ func Wallet_(args []string) {
 			wallets = append(wallets, wallet)
 		}
 		fmt.Println(wallets)
+	case "listunspent":
+		if len(args) < 2 {
+			fmt.Println("Usage: blockchain wallet listunspent holder")
+			return
+		}
+		var lock Script
+		if wallet := ws.Wallet(args[1]); wallet != nil {
+			lock = wallet.LockScript()
+		} else if ms := mss.Multisig(args[1]); ms != nil {
+			lock = ms.LockScript()
+		} else {
+			fmt.Println("Cli.Wallet: Failed to Get Wallet: Wallet does not exist")
+			return
+		}
+		bc := db.Blockchain()
+		u := db.UTXOSet()
+		if u == nil {
+			u = new(UTXOSet)
+			*u = make(UTXOSet)
+			u.Index(bc)
+			db.SetUTXOSet(u)
+		}
+		locked := db.LockedOutputs()
+		if locked == nil {
+			locked = new(LockedOutputs)
+		}
+		height := bc.Height()
+		for _, out := range bc.Mempool.View(u).UnspentOuts(lock) {
+			confirmations := 0
+			if out.Height >= 0 {
+				confirmations = height - out.Height + 1
+			}
+			fmt.Printf("%v %v confirmations: %v", out.Outpoint(), out.Value, confirmations)
+			if locked.Locked(out.TxHash, out.Index) {
+				fmt.Printf(" locked")
+			}
+			if !out.Mature(height + 1) {
+				fmt.Printf(" immature")
+			}
+			fmt.Println()
+		}
+	case "lock", "unlock":
+		if len(args) < 2 {
+			fmt.Printf("Usage: blockchain wallet %v hash:index\n", method)
+			return
+		}
+		txHash, idx, err := parseOutpoint(args[1])
+		if err != nil {
+			fmt.Printf("Cli.Wallet: Failed to Parse Output %v: %v\n", args[1], err)
+			return
+		}
+		locked := db.LockedOutputs()
+		if locked == nil {
+			locked = new(LockedOutputs)
+			*locked = make(LockedOutputs)
+		}
+		if method == "lock" {
+			locked.Lock(txHash, idx)
+		} else {
+			locked.Unlock(txHash, idx)
+		}
+		db.SetLockedOutputs(locked)
+	case "migrate":
+		db.SetWallets(ws)
+		for _, addr := range slices.Sorted(maps.Keys(*ws)) {
+			fmt.Printf("%v -> %v\n", (*ws)[addr].LegacyAddress(), addr)
+		}
 	case "delete":
 		holder := args[1]
 		ws.Delete(holder)
 		db.SetWallets(ws)
+	case "pubkey":
+		holder := args[1]
+		wallet := ws.Wallet(holder)
+		if wallet == nil {
+			fmt.Println("Cli.Wallet: Failed to Get Wallet: Wallet does not exist")
+			return
+		}
+		fmt.Printf("%x\n", wallet.PubKey())
 	}
 }
 
 func Send(args []string) {
 	if len(args) < 3 {
 		fmt.Printf(
-			"Usage: blockchain send from to amount - record a transfer transaction " +
-				"between wallets\n",
+			"Usage: blockchain send from to amount [--utxo hash:index]... [--feerate amount] [--strategy name] [--rbf] - " +
+				"record a transfer transaction between wallets\n",
 		)
 		return
 	}
 	from, to := args[0], args[1]
+	var utxos listFlag
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	fs.Var(&utxos, "utxo", "pinned input as hash:index")
+	feeRate := fs.String("feerate", "", "fee per 1000 bytes, estimated by default")
+	strategy := fs.String("strategy", DefaultCoinSelector, "coin selection strategy: bnb, largest, oldest or privacy")
+	rbf := fs.Bool("rbf", false, "signal that transaction may be replaced")
+	if err := fs.Parse(args[3:]); err != nil {
+		return
+	}
+	cc := &CoinControl{Strategy: *strategy, RBF: *rbf}
+	for _, utxo := range utxos {
+		txHash, idx, err := parseOutpoint(utxo)
+		if err != nil {
+			fmt.Printf("Cli.Send: Failed to Parse Input %v: %v\n", utxo, err)
+			return
+		}
+		cc.Inputs = append(cc.Inputs, &TxIn{txHash, idx, nil, SequenceFinal})
+	}
 	db := GetDatabase()
 	defer db.Close()
+	if locked := db.LockedOutputs(); locked != nil {
+		cc.Locked = *locked
+	}
 	ws := db.Wallets()
 	if ws == nil {
 		ws = new(Wallets)
 		*ws = make(Wallets)
 	}
-	sender := ws.Wallet(from)
-	if sender == nil {
-		fmt.Println("Cli.Send: Failed to Get Wallet: Wallet does not exist")
+	receiver, err := AddressScript(to)
+	if err != nil {
+		fmt.Printf("Cli.Send: Failed to Get Address: %v\n", err)
 		return
 	}
-	receiver := ws.Wallet(to)
-	if receiver == nil {
-		fmt.Println("Cli.Send: Failed to Get Wallet: Wallet does not exist")
+	amount, err := ParseAmount(args[2])
+	if err != nil {
+		fmt.Printf("Cli.Send: Failed to Record TransferTx: Invalid Amount Value: %v\n", err)
 		return
 	}
-	amount, err := strconv.Atoi(args[2])
-	if err != nil {
-		fmt.Printf("Cli.Send: Failed to Record TransferTx: Invalid Amount Value\n")
+	if amount < DustThreshold {
+		fmt.Printf("Cli.Send: Failed to Record TransferTx: Amount is below dust threshold %v\n", DustThreshold)
 		return
 	}
 	bc := db.Blockchain()
@@ -102,26 +354,121 @@ func Send(args []string) {
 		u.Index(bc)
 		db.SetUTXOSet(u)
 	}
-	bc.Send(sender, receiver, amount, u)
+	rate, err := bc.FeeRate(*feeRate)
+	if err != nil {
+		fmt.Printf("Cli.Send: Failed to Record TransferTx: Invalid Fee Rate: %v\n", err)
+		return
+	}
+	if sender := ws.Wallet(from); sender != nil {
+		tx, fee, err := bc.Send(sender, receiver, amount, rate, u, cc)
+		if err != nil {
+			fmt.Printf("Cli.Send: Failed to Record TransferTx: %v\n", err)
+			return
+		}
+		fmt.Printf("%x\n", tx.ID())
+		fmt.Printf("Fee: %v\n", fee)
+		return
+	}
+	mss := db.Multisigs()
+	if mss == nil || mss.Multisig(from) == nil {
+		fmt.Println("Cli.Send: Failed to Get Wallet: Wallet does not exist")
+		return
+	}
+	ms := mss.Multisig(from)
+	ptx, fee, err := PartialTransferTx(ms.LockScript(), ms.RedeemScript(), receiver, amount, rate, bc.Height()+1, bc.Mempool.View(u), cc)
+	if err != nil {
+		fmt.Printf("Cli.Send: Failed to Record TransferTx: %v\n", err)
+		return
+	}
+	ps := db.PartialTxs()
+	if ps == nil {
+		ps = new(PartialTxs)
+		*ps = make(PartialTxs)
+	}
+	(*ps)[ptx.ID()] = ptx
+	db.SetPartialTxs(ps)
+	fmt.Println(ptx.ID())
+	fmt.Printf("Fee: %v\n", fee)
 }
 
-func Mine(args []string) {
-	if len(args) < 1 {
-		fmt.Printf("Usage: blockchain mine miner - mine transactions from pool\n")
+func SendMany(args []string) {
+	if len(args) < 2 {
+		fmt.Printf(
+			"Usage: blockchain sendmany from [addr:amount...] [--file payments.csv|payments.json] " +
+				"[--feerate amount] [--strategy name] [--rbf] - pay many recipients in one transaction\n",
+		)
 		return
 	}
-	miner := args[0]
+	from := args[0]
+	var inline []string
+	rest := args[1:]
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		inline, rest = append(inline, rest[0]), rest[1:]
+	}
+	fs := flag.NewFlagSet("sendmany", flag.ContinueOnError)
+	file := fs.String("file", "", "CSV or JSON file with addresses and amounts")
+	feeRate := fs.String("feerate", "", "fee per 1000 bytes, estimated by default")
+	strategy := fs.String("strategy", DefaultCoinSelector, "coin selection strategy: bnb, largest, oldest or privacy")
+	rbf := fs.Bool("rbf", false, "signal that transaction may be replaced")
+	if err := fs.Parse(rest); err != nil {
+		return
+	}
+	entries := make([][2]string, 0)
+	for _, payment := range inline {
+		addr, amount, _ := strings.Cut(payment, ":")
+		entries = append(entries, [2]string{addr, amount})
+	}
+	if *file != "" {
+		fileEntries, err := readPayments(*file)
+		if err != nil {
+			fmt.Printf("Cli.SendMany: Failed to Read %v: %v\n", *file, err)
+			return
+		}
+		entries = append(entries, fileEntries...)
+	}
+	if len(entries) == 0 {
+		fmt.Println("Cli.SendMany: Failed to Record Transaction: No Recipients")
+		return
+	}
+	payments := make([]*Payment, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		lock, err := AddressScript(entry[0])
+		if err != nil {
+			fmt.Printf("Cli.SendMany: Failed to Get Address %v: %v\n", entry[0], err)
+			return
+		}
+		if seen[entry[0]] {
+			fmt.Printf("Cli.SendMany: Failed to Record Transaction: Duplicate Address %v\n", entry[0])
+			return
+		}
+		seen[entry[0]] = true
+		amount, err := ParseAmount(entry[1])
+		if err != nil {
+			fmt.Printf("Cli.SendMany: Failed to Record Transaction: Invalid Amount Value %v: %v\n", entry[1], err)
+			return
+		}
+		if amount < DustThreshold {
+			fmt.Printf("Cli.SendMany: Failed to Record Transaction: Amount %v is below dust threshold %v\n", amount, DustThreshold)
+			return
+		}
+		payments = append(payments, &Payment{lock, amount})
+	}
 	db := GetDatabase()
 	defer db.Close()
 	ws := db.Wallets()
-	if ws == nil {
-		ws = new(Wallets)
-		*ws = make(Wallets)
+	if ws == nil || ws.Wallet(from) == nil {
+		fmt.Println("Cli.SendMany: Failed to Get Wallet: Wallet does not exist")
+		return
+	}
+	cc := &CoinControl{Strategy: *strategy, RBF: *rbf}
+	if locked := db.LockedOutputs(); locked != nil {
+		cc.Locked = *locked
 	}
 	bc := db.Blockchain()
-	wallet := ws.Wallet(miner)
-	if wallet == nil {
-		fmt.Println("Cli.Mine: Failed to Get Wallet: Wallet does not exist")
+	rate, err := bc.FeeRate(*feeRate)
+	if err != nil {
+		fmt.Printf("Cli.SendMany: Failed to Record Transaction: Invalid Fee Rate: %v\n", err)
 		return
 	}
 	u := db.UTXOSet()
@@ -131,7 +478,1064 @@ func Mine(args []string) {
 		u.Index(bc)
 		db.SetUTXOSet(u)
 	}
-	bc.Mine(wallet, u)
+	tx, fee, err := bc.SendMany(ws.Wallet(from), payments, rate, u, cc)
+	if err != nil {
+		fmt.Printf("Cli.SendMany: Failed to Record Transaction: %v\n", err)
+		return
+	}
+	fmt.Printf("%x\n", tx.ID())
+	fmt.Printf("Fee: %v\n", fee)
+}
+
+// readPayments reads address and amount pairs from a CSV file, or from a JSON
+// array of objects with address and amount fields when path ends with .json.
+func readPayments(path string) ([][2]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([][2]string, 0)
+	if strings.HasSuffix(path, ".json") {
+		var payments []struct {
+			Address string
+			Amount  json.Number
+		}
+		if err := json.Unmarshal(data, &payments); err != nil {
+			return nil, err
+		}
+		for _, p := range payments {
+			entries = append(entries, [2]string{p.Address, p.Amount.String()})
+		}
+		return entries, nil
+	}
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		if len(record) != 2 {
+			return nil, fmt.Errorf("line %v: expected address and amount", i+1)
+		}
+		if i == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "address") {
+			continue
+		}
+		entries = append(entries, [2]string{strings.TrimSpace(record[0]), strings.TrimSpace(record[1])})
+	}
+	return entries, nil
+}
+
+func Tx_(args []string) {
+	if len(args) < 2 {
+		fmt.Printf(
+			"Usage:  blockchain tx command args...\n\t" +
+				"create from to amount file [--utxo hash:index]... [--feerate amount] [--strategy name] - create an unsigned transaction\n\t" +
+				"sign file holder [sighash] - add signatures of holder wallet\n\t" +
+				"combine file file... - merge signatures into the first file\n\t" +
+				"finalize file - build unlocking scripts from signatures\n\t" +
+				"submit file - record a finalized transaction into pool\n",
+		)
+		return
+	}
+	method := args[0]
+	db := GetDatabase()
+	defer db.Close()
+	switch method {
+	case "create":
+		if len(args) < 5 {
+			fmt.Println("Usage: blockchain tx create from to amount file [--utxo hash:index]... [--feerate amount] [--strategy name]")
+			return
+		}
+		var utxos listFlag
+		fs := flag.NewFlagSet("tx create", flag.ContinueOnError)
+		fs.Var(&utxos, "utxo", "pinned input as hash:index")
+		feeRate := fs.String("feerate", "", "fee per 1000 bytes, estimated by default")
+		strategy := fs.String("strategy", DefaultCoinSelector, "coin selection strategy: bnb, largest, oldest or privacy")
+		if err := fs.Parse(args[5:]); err != nil {
+			return
+		}
+		cc := &CoinControl{Strategy: *strategy}
+		for _, utxo := range utxos {
+			txHash, idx, err := parseOutpoint(utxo)
+			if err != nil {
+				fmt.Printf("Cli.Tx: Failed to Parse Input %v: %v\n", utxo, err)
+				return
+			}
+			cc.Inputs = append(cc.Inputs, &TxIn{txHash, idx, nil, SequenceFinal})
+		}
+		if locked := db.LockedOutputs(); locked != nil {
+			cc.Locked = *locked
+		}
+		from, err := AddressScript(args[1])
+		if err != nil {
+			fmt.Printf("Cli.Tx: Failed to Get Address: %v\n", err)
+			return
+		}
+		var redeem Script
+		if from.ScriptHash() != nil {
+			mss := db.Multisigs()
+			if mss == nil || mss.Multisig(args[1]) == nil {
+				fmt.Println("Cli.Tx: Failed to Get Multisig: Multisig does not exist")
+				return
+			}
+			redeem = mss.Multisig(args[1]).RedeemScript()
+		}
+		to, err := AddressScript(args[2])
+		if err != nil {
+			fmt.Printf("Cli.Tx: Failed to Get Address: %v\n", err)
+			return
+		}
+		amount, err := ParseAmount(args[3])
+		if err != nil {
+			fmt.Printf("Cli.Tx: Failed to Create Transaction: Invalid Amount Value: %v\n", err)
+			return
+		}
+		if amount < DustThreshold {
+			fmt.Printf("Cli.Tx: Failed to Create Transaction: Amount is below dust threshold %v\n", DustThreshold)
+			return
+		}
+		bc := db.Blockchain()
+		u := db.UTXOSet()
+		if u == nil {
+			u = new(UTXOSet)
+			*u = make(UTXOSet)
+			u.Index(bc)
+			db.SetUTXOSet(u)
+		}
+		rate, err := bc.FeeRate(*feeRate)
+		if err != nil {
+			fmt.Printf("Cli.Tx: Failed to Create Transaction: Invalid Fee Rate: %v\n", err)
+			return
+		}
+		ptx, fee, err := PartialTransferTx(from, redeem, to, amount, rate, bc.Height()+1, bc.Mempool.View(u), cc)
+		if err != nil {
+			fmt.Printf("Cli.Tx: Failed to Create Transaction: %v\n", err)
+			return
+		}
+		writePartialTx(args[4], ptx)
+		fmt.Println(ptx.ID())
+		fmt.Printf("Fee: %v\n", fee)
+	case "sign":
+		if len(args) < 3 {
+			fmt.Println("Usage: blockchain tx sign file holder [sighash]")
+			return
+		}
+		ptx := readPartialTx(args[1])
+		if ptx == nil {
+			return
+		}
+		ws := db.Wallets()
+		if ws == nil || ws.Wallet(args[2]) == nil {
+			fmt.Println("Cli.Tx: Failed to Get Wallet: Wallet does not exist")
+			return
+		}
+		flag := SigHashAll
+		if len(args) > 3 {
+			var err error
+			flag, err = ParseSigHashType(args[3])
+			if err != nil {
+				fmt.Printf("Cli.Tx: Failed to Sign Transaction: %v\n", err)
+				return
+			}
+		}
+		signed, err := ptx.Sign(ws.Wallet(args[2]), flag)
+		if err != nil {
+			fmt.Printf("Cli.Tx: Failed to Sign Transaction: %v\n", err)
+			return
+		}
+		if signed == 0 {
+			fmt.Println("Cli.Tx: Failed to Sign Transaction: Wallet is not a signer")
+			return
+		}
+		writePartialTx(args[1], ptx)
+		fmt.Printf("Signed %v inputs, complete: %v\n", signed, ptx.Complete())
+	case "combine":
+		ptx := readPartialTx(args[1])
+		if ptx == nil {
+			return
+		}
+		for _, path := range args[2:] {
+			other := readPartialTx(path)
+			if other == nil {
+				return
+			}
+			if err := ptx.Combine(other); err != nil {
+				fmt.Printf("Cli.Tx: Failed to Combine %v: %v\n", path, err)
+				return
+			}
+		}
+		writePartialTx(args[1], ptx)
+		fmt.Printf("Complete: %v\n", ptx.Complete())
+	case "finalize":
+		ptx := readPartialTx(args[1])
+		if ptx == nil {
+			return
+		}
+		if _, err := ptx.Finalize(); err != nil {
+			fmt.Printf("Cli.Tx: Failed to Finalize Transaction: %v\n", err)
+			return
+		}
+		writePartialTx(args[1], ptx)
+		fmt.Println(ptx.ID())
+	case "submit":
+		ptx := readPartialTx(args[1])
+		if ptx == nil {
+			return
+		}
+		bc := db.Blockchain()
+		u := db.UTXOSet()
+		if u == nil {
+			u = new(UTXOSet)
+			*u = make(UTXOSet)
+			u.Index(bc)
+		}
+		tx := ptx.Tx
+		if err := bc.Submit(tx, u); err != nil {
+			fmt.Printf("Cli.Tx: Failed to Submit Transaction: %v\n", err)
+			return
+		}
+		fmt.Printf("%x\n", tx.ID())
+	}
+}
+
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func RawTx_(args []string) {
+	if len(args) < 1 {
+		fmt.Printf(
+			"Usage:  blockchain rawtx command args...\n\t" +
+				"create --in hash:index... --out addr:amount... [--locktime n] [--rbf] - create an unsigned transaction\n\t" +
+				"decode hex - print transaction data\n\t" +
+				"send hex - record a signed transaction into pool\n\t" +
+				"sign hex holder [sighash] - sign inputs spendable by holder wallet\n",
+		)
+		return
+	}
+	method := args[0]
+	switch method {
+	case "create":
+		var ins, outs listFlag
+		fs := flag.NewFlagSet("rawtx create", flag.ContinueOnError)
+		fs.Var(&ins, "in", "input as hash:index")
+		fs.Var(&outs, "out", "output as addr:amount")
+		lockTime := fs.Int("locktime", 0, "transaction lock time")
+		rbf := fs.Bool("rbf", false, "signal that transaction may be replaced")
+		if err := fs.Parse(args[1:]); err != nil {
+			return
+		}
+		tx := &Tx{LockTime: *lockTime}
+		sequence := SequenceFinal
+		if *rbf {
+			sequence = SequenceRBF
+		}
+		for _, in := range ins {
+			txHash, txOutIdx, err := parseOutpoint(in)
+			if err != nil {
+				fmt.Printf("Cli.RawTx: Failed to Parse Input %v: %v\n", in, err)
+				return
+			}
+			tx.TxIn = append(tx.TxIn, &TxIn{txHash, txOutIdx, nil, sequence})
+		}
+		for _, out := range outs {
+			addr, value, _ := strings.Cut(out, ":")
+			lock, err := AddressScript(addr)
+			if err != nil {
+				fmt.Printf("Cli.RawTx: Failed to Parse Output %v: %v\n", out, err)
+				return
+			}
+			amount, err := ParseAmount(value)
+			if err != nil {
+				fmt.Printf("Cli.RawTx: Failed to Parse Output %v: Invalid Amount Value: %v\n", out, err)
+				return
+			}
+			tx.TxOut = append(tx.TxOut, &TxOut{amount, lock})
+		}
+		fmt.Printf("%x\n", tx.Bytes())
+	case "decode":
+		tx := decodeRawTx(args)
+		if tx == nil {
+			return
+		}
+		data, err := json.MarshalIndent(tx, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(data))
+	case "send":
+		tx := decodeRawTx(args)
+		if tx == nil {
+			return
+		}
+		db := GetDatabase()
+		defer db.Close()
+		bc := db.Blockchain()
+		u := db.UTXOSet()
+		if u == nil {
+			u = new(UTXOSet)
+			*u = make(UTXOSet)
+			u.Index(bc)
+		}
+		if err := bc.Submit(tx, u); err != nil {
+			fmt.Printf("Cli.RawTx: Failed to Send Transaction: %v\n", err)
+			return
+		}
+		fmt.Printf("%x\n", tx.ID())
+	case "sign":
+		if len(args) < 3 {
+			fmt.Println("Usage: blockchain rawtx sign hex holder [sighash]")
+			return
+		}
+		tx := decodeRawTx(args)
+		if tx == nil {
+			return
+		}
+		flag := SigHashAll
+		if len(args) > 3 {
+			var err error
+			flag, err = ParseSigHashType(args[3])
+			if err != nil {
+				fmt.Printf("Cli.RawTx: Failed to Sign Transaction: %v\n", err)
+				return
+			}
+		}
+		db := GetDatabase()
+		defer db.Close()
+		ws := db.Wallets()
+		if ws == nil || ws.Wallet(args[2]) == nil {
+			fmt.Println("Cli.RawTx: Failed to Get Wallet: Wallet does not exist")
+			return
+		}
+		wallet := ws.Wallet(args[2])
+		bc := db.Blockchain()
+		u := db.UTXOSet()
+		if u == nil {
+			u = new(UTXOSet)
+			*u = make(UTXOSet)
+			u.Index(bc)
+		}
+		u = bc.Mempool.View(u)
+		signed := 0
+		for idx, in := range tx.TxIn {
+			prevOut := u.TxOut(in.TxOutHash, in.TxOutIndex)
+			if prevOut != nil && prevOut.LockedWith(wallet.LockScript()) {
+				if err := tx.SignInput(idx, wallet, flag); err != nil {
+					fmt.Printf("Cli.RawTx: Failed to Sign Transaction: %v\n", err)
+					return
+				}
+				signed++
+			}
+		}
+		if signed == 0 {
+			fmt.Println("Cli.RawTx: Failed to Sign Transaction: No inputs spendable by wallet")
+			return
+		}
+		fmt.Printf("%x\n", tx.Bytes())
+	}
+}
+
+func parseOutpoint(s string) ([]byte, int, error) {
+	hash, idx, _ := strings.Cut(s, ":")
+	txHash, err := hex.DecodeString(hash)
+	if err != nil || len(txHash) != hashSize {
+		return nil, 0, errors.New("Invalid Hash")
+	}
+	txOutIdx, err := strconv.Atoi(idx)
+	if err != nil || txOutIdx < 0 {
+		return nil, 0, errors.New("Invalid Index")
+	}
+	return txHash, txOutIdx, nil
+}
+
+func decodeRawTx(args []string) *Tx {
+	if len(args) < 2 {
+		fmt.Printf("Usage: blockchain rawtx %v hex\n", args[0])
+		return nil
+	}
+	data, err := hex.DecodeString(args[1])
+	if err != nil {
+		fmt.Println("Cli.RawTx: Failed to Decode Transaction: Invalid Hex String")
+		return nil
+	}
+	tx, err := DecodeTx(data)
+	if err != nil {
+		fmt.Printf("Cli.RawTx: Failed to Decode Transaction: %v\n", err)
+		return nil
+	}
+	return tx
+}
+
+func readPartialTx(path string) *PartialTx {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Cli.Tx: Failed to Read %v: %v\n", path, err)
+		return nil
+	}
+	ptx, err := DecodePartialTx(data)
+	if err != nil {
+		fmt.Printf("Cli.Tx: Failed to Decode %v: %v\n", path, err)
+		return nil
+	}
+	return ptx
+}
+
+func writePartialTx(path string, ptx *PartialTx) {
+	err := os.WriteFile(path, ptx.Encode(), 0600)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func EstimateFee(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: blockchain estimatefee target - estimate fee rate to confirm within target blocks")
+		return
+	}
+	target, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Println("Cli.EstimateFee: Failed to Estimate Fee: Invalid Target")
+		return
+	}
+	db := GetDatabase()
+	defer db.Close()
+	bc := db.Blockchain()
+	rate, err := bc.EstimateFee(target)
+	if err != nil {
+		fmt.Printf("Cli.EstimateFee: Failed to Estimate Fee: %v\n", err)
+		return
+	}
+	fmt.Printf("Fee Rate: %v per kB\n", rate)
+}
+
+func Mempool_(args []string) {
+	if len(args) < 1 {
+		fmt.Printf(
+			"Usage:  blockchain mempool command args...\n\t" +
+				"info - print mempool size, fees and limits\n\t" +
+				"list - list mempool transactions\n\t" +
+				"remove txid - remove transaction and its descendants from mempool\n",
+		)
+		return
+	}
+	method := args[0]
+	db := GetDatabase()
+	defer db.Close()
+	bc := db.Blockchain()
+	mp := bc.Mempool
+	switch method {
+	case "info":
+		fmt.Printf("Transactions: %v\n", len(mp.Entries))
+		fmt.Printf("Size: %v / %v bytes\n", mp.Size(), params.MempoolMaxSize)
+		fmt.Printf("Fees: %v\n", mp.Fees())
+		fmt.Printf("Min Relay Fee: %v per kB\n", params.MinRelayFee)
+		fmt.Printf("Expiry: %v seconds\n", params.MempoolExpiry)
+	case "list":
+		now := int(time.Now().Unix())
+		for _, txid := range mp.sorted() {
+			e := mp.Entries[txid]
+			fmt.Printf(
+				"%v size: %v fee: %v rate: %.2f age: %vs parents: %v children: %v rbf: %v\n",
+				txid, e.Size, e.Fee, e.FeeRate(), now-e.Time,
+				len(mp.Parents(txid)), len(mp.Children(txid)), e.Tx.SignalsRBF(),
+			)
+		}
+	case "remove":
+		if len(args) < 2 {
+			fmt.Println("Usage: blockchain mempool remove txid")
+			return
+		}
+		removed := mp.Remove(args[1])
+		if removed == nil {
+			fmt.Println("Cli.Mempool: Failed to Remove Transaction: Transaction does not exist")
+			return
+		}
+		db.RemoveMempoolEntries(removed)
+		for _, txid := range removed {
+			fmt.Println(txid)
+		}
+	}
+}
+
+func Mine(args []string) {
+	if len(args) < 1 {
+		fmt.Printf("Usage: blockchain mine miner [--continuous] [--pool url] [--threads n] - mine transactions from mempool\n")
+		return
+	}
+	miner := args[0]
+	fs := flag.NewFlagSet("mine", flag.ContinueOnError)
+	continuous := fs.Bool("continuous", false, "keep mining new blocks until interrupted")
+	pool := fs.String("pool", "", "mine shares for the pool at url, paid to miner address")
+	threads := fs.Int("threads", 0, "number of mining threads, all cores by default")
+	if err := fs.Parse(args[1:]); err != nil {
+		return
+	}
+	if *pool != "" {
+		if _, err := AddressScript(miner); err != nil {
+			fmt.Printf("Cli.Mine: Failed to Parse Address: %v\n", err)
+			return
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		MinePool(ctx, strings.TrimSuffix(*pool, "/"), miner, NewMiner(*threads))
+		fmt.Println("Mining stopped")
+		return
+	}
+	db := GetDatabase()
+	ws := db.Wallets()
+	if ws == nil {
+		ws = new(Wallets)
+		*ws = make(Wallets)
+	}
+	wallet := ws.Wallet(miner)
+	if wallet == nil {
+		db.Close()
+		fmt.Println("Cli.Mine: Failed to Get Wallet: Wallet does not exist")
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	m := NewMiner(*threads)
+	m.Signer = wallet
+	if *continuous {
+		db.Close()
+		MineContinuous(ctx, wallet.LockScript(), m)
+		fmt.Println("Mining stopped")
+		return
+	}
+	defer db.Close()
+	bc := db.Blockchain()
+	u := db.UTXOSet()
+	if u == nil {
+		u = new(UTXOSet)
+		*u = make(UTXOSet)
+		u.Index(bc)
+		db.SetUTXOSet(u)
+	}
+	fmt.Println("Mining a New Block")
+	block, err := bc.Mine(ctx, wallet, u, m)
+	if err != nil {
+		fmt.Printf("Cli.Mine: Failed to Mine Block: %v\n", err)
+		return
+	}
+	fmt.Printf("%x\n", block.Header.Hash)
+}
+
+func PoWBench(args []string) {
+	fs := flag.NewFlagSet("powbench", flag.ContinueOnError)
+	seconds := fs.Int("seconds", 5, "duration of benchmark of each function")
+	threads := fs.Int("threads", 0, "number of mining threads, all cores by default")
+	if err := fs.Parse(args); err != nil {
+		return
+	}
+	names := fs.Args()
+	if len(names) == 0 {
+		for name := range PoWs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		pow, err := GetPoW(name)
+		if err != nil {
+			fmt.Printf("Cli.PoWBench: Failed to Get PoW: %v\n", err)
+			return
+		}
+		m := NewMiner(*threads)
+		m.PoW = pow
+		rate := m.Benchmark(time.Duration(*seconds) * time.Second)
+		fmt.Printf("%-9v %.2f kH/s\n", name, rate/1000)
+	}
+}
+
+func PoA_(args []string) {
+	if len(args) < 1 {
+		fmt.Printf(
+			"Usage:  blockchain poa command args...\n\t" +
+				"discard address - withdraw proposal about signer\n\t" +
+				"proposals - list proposals voted for in signed blocks\n\t" +
+				"propose address [--remove] - vote to add or remove signer\n\t" +
+				"signers - list authorized signers and pending votes\n",
+		)
+		return
+	}
+	method := args[0]
+	db := GetDatabase()
+	defer db.Close()
+	proposals := db.Proposals()
+	if proposals == nil {
+		proposals = new(Proposals)
+		*proposals = make(Proposals)
+	}
+	switch method {
+	case "discard":
+		if len(args) < 2 {
+			fmt.Println("Usage: blockchain poa discard address")
+			return
+		}
+		if _, ok := (*proposals)[args[1]]; !ok {
+			fmt.Println("Cli.PoA: Failed to Discard Proposal: Proposal does not exist")
+			return
+		}
+		delete(*proposals, args[1])
+		db.SetProposals(proposals)
+	case "proposals":
+		for _, addr := range proposals.Sorted() {
+			vote := "remove"
+			if (*proposals)[addr] {
+				vote = "add"
+			}
+			fmt.Printf("%v %v\n", vote, addr)
+		}
+	case "propose":
+		if len(args) < 2 {
+			fmt.Println("Usage: blockchain poa propose address [--remove]")
+			return
+		}
+		fs := flag.NewFlagSet("propose", flag.ContinueOnError)
+		remove := fs.Bool("remove", false, "vote to remove signer")
+		if err := fs.Parse(args[2:]); err != nil {
+			return
+		}
+		if lock, err := AddressScript(args[1]); err != nil || lock.PubKeyHash() == nil {
+			fmt.Println("Cli.PoA: Failed to Propose: Invalid signer address")
+			return
+		}
+		(*proposals)[args[1]] = !*remove
+		db.SetProposals(proposals)
+	case "signers":
+		engine, ok := params.Engine().(*PoA)
+		if !ok {
+			fmt.Printf("Cli.PoA: Failed to Get Signers: Consensus engine is %v\n", params.Consensus)
+			return
+		}
+		bc := db.Blockchain()
+		snap, err := engine.Snapshot(bc, bc.LastHash())
+		if err != nil {
+			fmt.Printf("Cli.PoA: Failed to Get Signers: %v\n", err)
+			return
+		}
+		inTurn, _ := snap.InTurn(bc.Height() + 1)
+		for _, signer := range snap.Signers {
+			if signer == inTurn {
+				fmt.Printf("%v (in turn)\n", signer)
+			} else {
+				fmt.Println(signer)
+			}
+		}
+		for candidate, votes := range snap.Votes {
+			for signer, authorize := range votes {
+				vote := "remove"
+				if authorize {
+					vote = "add"
+				}
+				fmt.Printf("Vote: %v %v by %v\n", vote, candidate, signer)
+			}
+		}
+	}
+}
+
+func BFT_(args []string) {
+	if len(args) < 1 {
+		fmt.Printf(
+			"Usage:  blockchain bft command args...\n\t" +
+				"validators - list validators and proposer of the next block\n",
+		)
+		return
+	}
+	switch args[0] {
+	case "validators":
+		db := GetDatabase()
+		defer db.Close()
+		validators := Validators()
+		if len(validators) == 0 {
+			fmt.Println("Cli.BFT: Failed to Get Validators: No validators in chain params")
+			return
+		}
+		ws := db.Wallets()
+		proposer := Proposer(validators, db.Blockchain().Height()+1, 0)
+		for _, addr := range validators {
+			fmt.Print(addr)
+			if ws != nil && ws.Wallet(addr) != nil {
+				fmt.Print(" (local)")
+			}
+			if addr == proposer {
+				fmt.Print(" (proposer)")
+			}
+			fmt.Println()
+		}
+		fmt.Printf("Quorum: %v of %v\n", Quorum(len(validators)), len(validators))
+	}
+}
+
+func Pool(args []string) {
+	fs := flag.NewFlagSet("pool", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	shareDifficulty := fs.Int("sharediff", DefaultShareDifficulty, "difficulty of shares")
+	window := fs.Int("window", DefaultPPLNSWindow, "number of last shares paid out")
+	if err := fs.Parse(args); err != nil {
+		return
+	}
+	if *window < 1 {
+		fmt.Println("Cli.Pool: Failed to Start Pool: Window must be at least 1 share")
+		return
+	}
+	db := GetDatabase()
+	difficulty := db.Blockchain().Difficulty
+	db.Close()
+	if *shareDifficulty < 1 || *shareDifficulty > difficulty {
+		fmt.Printf("Cli.Pool: Failed to Start Pool: Share difficulty must be between 1 and block difficulty %v\n", difficulty)
+		return
+	}
+	fmt.Printf("Pool listening on %v\n", *addr)
+	if err := NewMiningPool(*shareDifficulty, *window).ListenAndServe(*addr); err != nil {
+		fmt.Printf("Cli.Pool: Failed to Serve: %v\n", err)
+	}
+}
+
+func Serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	validator := fs.String("validator", "", "run BFT validator of wallet with validators of peers")
+	outbound := fs.Int("outbound", DefaultOutbound, "target number of outbound peers")
+	if err := fs.Parse(args); err != nil {
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	s := NewServer()
+	go s.MaintainPeers(ctx, *outbound, peerInterval)
+	if *validator != "" {
+		db := GetDatabase()
+		_, bft := params.Engine().(BFT)
+		var wallet *Wallet
+		if ws := db.Wallets(); ws != nil {
+			wallet = ws.Wallet(*validator)
+		}
+		db.Close()
+		if !bft {
+			fmt.Println("Cli.Serve: Failed to Run Validator: Consensus is not bft")
+			return
+		}
+		if wallet == nil {
+			fmt.Println("Cli.Serve: Failed to Get Wallet: Wallet does not exist")
+			return
+		}
+		go s.Validate(ctx, wallet)
+	}
+	fmt.Printf("Listening on %v\n", *addr)
+	if err := s.ListenAndServe(*addr); err != nil {
+		fmt.Printf("Cli.Serve: Failed to Serve: %v\n", err)
+	}
+}
+
+func Stake_(args []string) {
+	if len(args) < 1 {
+		fmt.Printf(
+			"Usage:  blockchain stake command args...\n\t" +
+				"create from amount [--blocks n] - lock coins of wallet as stake for n blocks\n\t" +
+				"list - list stakes, slashed stakers and leaders of next slots\n\t" +
+				"withdraw from - spend unlocked stakes of wallet back to it\n",
+		)
+		return
+	}
+	method := args[0]
+	db := GetDatabase()
+	defer db.Close()
+	bc := db.Blockchain()
+	u := db.UTXOSet()
+	if u == nil {
+		u = new(UTXOSet)
+		*u = make(UTXOSet)
+		u.Index(bc)
+		db.SetUTXOSet(u)
+	}
+	ws := db.Wallets()
+	if ws == nil {
+		ws = new(Wallets)
+		*ws = make(Wallets)
+	}
+	switch method {
+	case "create":
+		if len(args) < 3 {
+			fmt.Println("Usage: blockchain stake create from amount [--blocks n]")
+			return
+		}
+		fs := flag.NewFlagSet("create", flag.ContinueOnError)
+		blocks := fs.Int("blocks", DefaultStakeLock, "number of blocks to lock stake for")
+		if err := fs.Parse(args[3:]); err != nil {
+			return
+		}
+		w := ws.Wallet(args[1])
+		if w == nil {
+			fmt.Println("Cli.Stake: Failed to Get Wallet: Wallet does not exist")
+			return
+		}
+		amount, err := ParseAmount(args[2])
+		if err != nil {
+			fmt.Printf("Cli.Stake: Failed to Stake: Invalid Amount Value: %v\n", err)
+			return
+		}
+		if *blocks < 1 {
+			fmt.Println("Cli.Stake: Failed to Stake: Stake must be locked for at least 1 block")
+			return
+		}
+		cc := &CoinControl{}
+		if locked := db.LockedOutputs(); locked != nil {
+			cc.Locked = *locked
+		}
+		tx, err := bc.Stake(w, amount, bc.Height()+1+*blocks, u, cc)
+		if err != nil {
+			fmt.Printf("Cli.Stake: Failed to Stake: %v\n", err)
+			return
+		}
+		fmt.Printf("%x\n", tx.ID())
+	case "list":
+		engine, ok := params.Engine().(*PoS)
+		if !ok {
+			fmt.Printf("Cli.Stake: Failed to Get Stakes: Consensus engine is %v\n", params.Consensus)
+			return
+		}
+		snap, err := engine.Snapshot(bc, bc.LastHash())
+		if err != nil {
+			fmt.Printf("Cli.Stake: Failed to Get Stakes: %v\n", err)
+			return
+		}
+		ops := slices.Sorted(maps.Keys(snap.Stakes))
+		for _, op := range ops {
+			stake := snap.Stakes[op]
+			fmt.Printf("%v %v %v unlock %v\n", op, stake.Address, stake.Value, stake.Unlock)
+		}
+		for _, addr := range slices.Sorted(maps.Keys(snap.Slashed)) {
+			fmt.Printf("Slashed: %v\n", addr)
+		}
+		height, next := bc.Height()+1, slot(int(time.Now().Unix()))
+		if parent := db.Block(bc.LastHash()); parent != nil {
+			next = max(next, slot(parent.Header.Timestamp)+1)
+		}
+		for s := next; s < next+5; s++ {
+			if leader, err := snap.Leader(bc.LastHash(), height, s); err == nil {
+				fmt.Printf("Slot %v: %v\n", s, leader)
+			}
+		}
+	case "withdraw":
+		if len(args) < 2 {
+			fmt.Println("Usage: blockchain stake withdraw from")
+			return
+		}
+		w := ws.Wallet(args[1])
+		if w == nil {
+			fmt.Println("Cli.Stake: Failed to Get Wallet: Wallet does not exist")
+			return
+		}
+		tx, err := bc.WithdrawStake(w, u)
+		if err != nil {
+			fmt.Printf("Cli.Stake: Failed to Withdraw: %v\n", err)
+			return
+		}
+		fmt.Printf("%x\n", tx.ID())
+	}
+}
+
+func Sync(args []string) {
+	var peers listFlag
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	fs.Var(&peers, "peer", "URL of a peer serving the HTTP API, known peers are used by default")
+	outbound := fs.Int("outbound", DefaultOutbound, "number of known peers to sync from")
+	workers := fs.Int("workers", DefaultSyncWorkers, "number of parallel block downloads")
+	if err := fs.Parse(args); err != nil {
+		fmt.Println("Usage: blockchain sync [--peer url]... [--outbound n] [--workers n] - download blockchain from peers headers first")
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	db := GetDatabase()
+	am := db.AddrMan()
+	if am == nil {
+		am = new(AddrMan)
+		*am = make(AddrMan)
+	}
+	var selected []string
+	for _, peer := range peers {
+		addr, err := NormalizePeer(peer)
+		if err != nil {
+			db.Close()
+			fmt.Printf("Cli.Sync: Failed to Parse Peer %v: %v\n", peer, err)
+			return
+		}
+		if am.Banned(addr, int(time.Now().Unix())) {
+			fmt.Printf("Skipping banned peer %v\n", addr)
+			continue
+		}
+		selected = append(selected, addr)
+	}
+	if len(peers) == 0 {
+		selected = ConnectPeers(ctx, func(fn func(am *AddrMan) bool) { fn(am) }, nil, *outbound)
+		db.SetAddrMan(am)
+	}
+	db.Close()
+	if len(selected) == 0 {
+		fmt.Println("Cli.Sync: Failed to Sync: No peers to sync from")
+		return
+	}
+	s := NewSyncer(selected, *workers)
+	if err := s.Sync(ctx); err != nil {
+		fmt.Printf("Cli.Sync: Failed to Sync: %v\n", err)
+		return
+	}
+	fmt.Printf("Synced %v blocks, height %v\n", s.Status.BlockHeight-s.Status.StartHeight, s.Status.BlockHeight)
+}
+
+func GetSyncStatus() {
+	db := GetDatabase()
+	defer db.Close()
+	s := db.SyncStatus()
+	if s == nil {
+		fmt.Println("Cli.GetSyncStatus: Failed to Get Status: Node has not synced yet")
+		return
+	}
+	progress := 100.0
+	if total := s.TargetHeight - s.StartHeight; total > 0 {
+		progress = float64(s.BlockHeight-s.StartHeight) * 100 / float64(total)
+	}
+	fmt.Printf("State: %v\n", s.State)
+	fmt.Printf("Peers: %v\n", strings.Join(s.Peers, " "))
+	fmt.Printf("Headers: %v/%v\n", s.HeaderHeight, s.TargetHeight)
+	fmt.Printf("Blocks: %v/%v (%.1f%%)\n", s.BlockHeight, s.TargetHeight, progress)
+	fmt.Printf("Started: %v\n", time.Unix(int64(s.Started), 0).Format(time.DateTime))
+	fmt.Printf("Updated: %v\n", time.Unix(int64(s.Updated), 0).Format(time.DateTime))
+	if s.Error != "" {
+		fmt.Printf("Error: %v\n", s.Error)
+	}
+}
+
+func Peers_(args []string) {
+	if len(args) < 1 {
+		fmt.Printf(
+			"Usage:  blockchain peers command args...\n\t" +
+				"add url - add a peer to address manager\n\t" +
+				"ban addr [--duration seconds] [--reason text] - ban host of a peer URL or an inbound host\n\t" +
+				"connect [--outbound n] - select reachable peers and learn their addresses\n\t" +
+				"list - list known peers, their misbehaviour scores and bans\n\t" +
+				"unban addr - lift ban of a peer\n",
+		)
+		return
+	}
+	method := args[0]
+	db := GetDatabase()
+	defer func() { db.Close() }()
+	am := db.AddrMan()
+	if am == nil {
+		am = new(AddrMan)
+		*am = make(AddrMan)
+	}
+	now := int(time.Now().Unix())
+	switch method {
+	case "add":
+		if len(args) < 2 {
+			fmt.Println("Usage: blockchain peers add url")
+			return
+		}
+		if _, err := NormalizePeer(args[1]); err != nil {
+			fmt.Printf("Cli.Peers: Failed to Add Peer: %v\n", err)
+			return
+		}
+		if !am.Add(args[1], "manual") {
+			fmt.Println("Cli.Peers: Failed to Add Peer: Peer is already known")
+			return
+		}
+		db.SetAddrMan(am)
+	case "ban":
+		if len(args) < 2 {
+			fmt.Println("Usage: blockchain peers ban addr [--duration seconds] [--reason text]")
+			return
+		}
+		fs := flag.NewFlagSet("ban", flag.ContinueOnError)
+		duration := fs.Int("duration", params.BanTime, "ban duration in seconds")
+		reason := fs.String("reason", "manual ban", "reason of the ban")
+		if err := fs.Parse(args[2:]); err != nil {
+			return
+		}
+		if *duration < 1 {
+			fmt.Println("Cli.Peers: Failed to Ban Peer: Duration must be at least 1 second")
+			return
+		}
+		addr := args[1]
+		if normalized, err := NormalizePeer(addr); err == nil {
+			addr = normalized
+		}
+		am.Ban(addr, *reason, now+*duration)
+		db.SetAddrMan(am)
+	case "connect":
+		fs := flag.NewFlagSet("connect", flag.ContinueOnError)
+		outbound := fs.Int("outbound", DefaultOutbound, "target number of outbound peers")
+		if err := fs.Parse(args[1:]); err != nil {
+			return
+		}
+		db.Close()
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		connected := ConnectPeers(ctx, func(fn func(am *AddrMan) bool) { fn(am) }, nil, *outbound)
+		db = GetDatabase()
+		db.SetAddrMan(am)
+		for _, addr := range connected {
+			fmt.Println(addr)
+		}
+		fmt.Printf("Connected to %v of %v outbound peers, %v known\n", len(connected), *outbound, len(*am))
+	case "list":
+		for _, addr := range am.Sorted() {
+			p := (*am)[addr]
+			host := am.Host(addr)
+			score := 0
+			if host != nil {
+				score = host.Score
+			}
+			fmt.Printf("%v %v score %v failures %v", addr, p.Source, score, p.Failures)
+			if p.LastSeen > 0 {
+				fmt.Printf(" seen %v", time.Unix(int64(p.LastSeen), 0).Format(time.DateTime))
+			}
+			if am.Banned(addr, now) {
+				fmt.Printf(" banned until %v: %v", time.Unix(int64(host.BannedUntil), 0).Format(time.DateTime), host.BanReason)
+			}
+			fmt.Println()
+		}
+	case "unban":
+		if len(args) < 2 {
+			fmt.Println("Usage: blockchain peers unban addr")
+			return
+		}
+		addr := args[1]
+		if normalized, err := NormalizePeer(addr); err == nil {
+			addr = normalized
+		}
+		if !am.Unban(addr) {
+			fmt.Println("Cli.Peers: Failed to Unban Peer: Peer is not banned")
+			return
+		}
+		db.SetAddrMan(am)
+	}
+}
+
+func Supply() {
+	db := GetDatabase()
+	defer db.Close()
+	bc := db.Blockchain()
+	height := bc.Height()
+	fmt.Printf("Height: %v\n", height)
+	fmt.Printf("Issued Supply: %v\n", bc.Supply())
+	if supplyCap := params.SupplyCap(); supplyCap < 0 {
+		fmt.Printf("Supply Cap: unbounded (tail emission %v)\n", params.TailEmission)
+	} else {
+		fmt.Printf("Supply Cap: %v\n", supplyCap)
+	}
+	fmt.Printf("Block Subsidy: %v\n", params.Subsidy(height+1))
+	fmt.Printf("Next Halving Height: %v\n", params.NextHalving(height+1))
 }
 
 func Verify() {
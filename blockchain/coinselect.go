@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const maxBnBTries = 100000
+
+type UnspentOut struct {
+	TxHash []byte
+	Index  int
+	*UTXO
+}
+
+func (c *UnspentOut) Outpoint() string {
+	return outpoint(c.TxHash, c.Index)
+}
+
+// CoinSelector picks coins worth at least amount, or returns nil.
+type CoinSelector func(coins []*UnspentOut, amount Amount) []*UnspentOut
+
+var CoinSelectors = map[string]CoinSelector{
+	"bnb":     SelectBranchAndBound,
+	"largest": SelectLargestFirst,
+	"oldest":  SelectOldestFirst,
+	"privacy": SelectPrivacy,
+}
+
+const DefaultCoinSelector = "bnb"
+
+// CoinControl pins inputs of a transaction, excludes locked outputs from
+// automatic selection and chooses the selection strategy. RBF opts in to
+// replace-by-fee.
+type CoinControl struct {
+	Strategy string
+	Inputs   []*TxIn
+	Locked   LockedOutputs
+	RBF      bool
+}
+
+// SelectCoins falls back to largest first selection when branch and bound
+// finds no spend without change.
+func SelectCoins(strategy string, coins []*UnspentOut, amount Amount) ([]*UnspentOut, error) {
+	if strategy == "" {
+		strategy = DefaultCoinSelector
+	}
+	selector, ok := CoinSelectors[strategy]
+	if !ok {
+		return nil, fmt.Errorf("unknown coin selection strategy %v", strategy)
+	}
+	selected := selector(coins, amount)
+	if selected == nil && strategy == "bnb" {
+		selected = SelectLargestFirst(coins, amount)
+	}
+	if selected == nil {
+		return nil, errors.New("insufficient balance")
+	}
+	return selected, nil
+}
+
+func sortCoins(coins []*UnspentOut, less func(a, b *UnspentOut) bool) []*UnspentOut {
+	sorted := append([]*UnspentOut(nil), coins...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+	return sorted
+}
+
+func accumulate(coins []*UnspentOut, amount Amount) []*UnspentOut {
+	total := Amount(0)
+	for i, coin := range coins {
+		total += coin.Value
+		if total >= amount {
+			return coins[:i+1]
+		}
+	}
+	return nil
+}
+
+// SelectBranchAndBound searches for coins which pay the amount without
+// change, wasting less than the dust threshold which change would cost.
+func SelectBranchAndBound(coins []*UnspentOut, amount Amount) []*UnspentOut {
+	sorted := sortCoins(coins, func(a, b *UnspentOut) bool { return a.Value > b.Value })
+	remaining := make([]Amount, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value
+	}
+	var best []*UnspentOut
+	bestExcess, tries := Amount(-1), 0
+	var search func(i int, total Amount, picked []*UnspentOut)
+	search = func(i int, total Amount, picked []*UnspentOut) {
+		tries++
+		if tries > maxBnBTries || total >= amount+DustThreshold {
+			return
+		}
+		if total >= amount {
+			if bestExcess < 0 || total-amount < bestExcess {
+				best, bestExcess = append([]*UnspentOut(nil), picked...), total-amount
+			}
+			return
+		}
+		if i == len(sorted) || total+remaining[i] < amount {
+			return
+		}
+		search(i+1, total+sorted[i].Value, append(picked, sorted[i]))
+		search(i+1, total, picked)
+	}
+	search(0, 0, nil)
+	return best
+}
+
+func SelectLargestFirst(coins []*UnspentOut, amount Amount) []*UnspentOut {
+	return accumulate(sortCoins(coins, func(a, b *UnspentOut) bool { return a.Value > b.Value }), amount)
+}
+
+// SelectOldestFirst spends unconfirmed coins last.
+func SelectOldestFirst(coins []*UnspentOut, amount Amount) []*UnspentOut {
+	age := func(c *UnspentOut) int {
+		if c.Height < 0 {
+			return int(^uint(0) >> 1)
+		}
+		return c.Height
+	}
+	return accumulate(sortCoins(coins, func(a, b *UnspentOut) bool { return age(a) < age(b) }), amount)
+}
+
+// SelectPrivacy spends the smallest single coin which covers the amount, and
+// otherwise the fewest coins, so that a transaction links as few of them as possible.
+func SelectPrivacy(coins []*UnspentOut, amount Amount) []*UnspentOut {
+	for _, coin := range sortCoins(coins, func(a, b *UnspentOut) bool { return a.Value < b.Value }) {
+		if coin.Value >= amount {
+			return []*UnspentOut{coin}
+		}
+	}
+	return SelectLargestFirst(coins, amount)
+}
+
+type LockedOutputs map[string]bool
+
+func (l *LockedOutputs) Lock(txHash []byte, idx int) {
+	(*l)[outpoint(txHash, idx)] = true
+}
+
+func (l *LockedOutputs) Unlock(txHash []byte, idx int) {
+	delete(*l, outpoint(txHash, idx))
+}
+
+func (l *LockedOutputs) Locked(txHash []byte, idx int) bool {
+	return (*l)[outpoint(txHash, idx)]
+}
+
+func (l *LockedOutputs) Serialize() []byte {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(l)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func LockedOutputsDeserialize(data []byte) *LockedOutputs {
+	l := &LockedOutputs{}
+	buf := bytes.NewBuffer(data)
+	dec := gob.NewDecoder(buf)
+	err := dec.Decode(l)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// ChangeOutputs holds index of change output of wallet transactions by ID.
+type ChangeOutputs map[string]int
+
+func (c *ChangeOutputs) Serialize() []byte {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(c)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func ChangeOutputsDeserialize(data []byte) *ChangeOutputs {
+	c := &ChangeOutputs{}
+	buf := bytes.NewBuffer(data)
+	dec := gob.NewDecoder(buf)
+	err := dec.Decode(c)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
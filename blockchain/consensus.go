@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Engine decides who may produce blocks. Prepare sets consensus fields of a
+// block template, Seal completes it without database access, so that sealing
+// may run while database is closed, and VerifyHeader checks header and seal
+// of a block on top of its parent.
+type Engine interface {
+	Prepare(bc *Blockchain, b *Block, m *Miner) error
+	Seal(ctx context.Context, b *Block, m *Miner) (*Block, error)
+	VerifyHeader(bc *Blockchain, b *Block) error
+}
+
+var Engines = map[string]Engine{
+	"pow": PoWEngine{},
+	"poa": NewPoA(),
+	"pos": NewPoS(),
+	"bft": BFT{},
+}
+
+const DefaultEngine = "pow"
+
+func GetEngine(name string) (Engine, error) {
+	engine, ok := Engines[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown consensus engine %v", name)
+	}
+	return engine, nil
+}
+
+// PoWEngine accepts blocks whose proof of work hash is below target.
+type PoWEngine struct{}
+
+func (PoWEngine) Prepare(bc *Blockchain, b *Block, m *Miner) error {
+	b.Header.Version = blockVersion
+	b.Seal = nil
+	return nil
+}
+
+func (PoWEngine) Seal(ctx context.Context, b *Block, m *Miner) (*Block, error) {
+	return m.Mine(ctx, b, difficulty)
+}
+
+func (PoWEngine) VerifyHeader(bc *Blockchain, b *Block) error {
+	if b.Header.Version != blockVersion {
+		return errors.New("unexpected block version")
+	}
+	if bytes.Compare(b.Header.PoWHash(), Target(bc.Difficulty)) >= 0 {
+		return errors.New("proof of work is above target")
+	}
+	return nil
+}
+
+// EvidenceReporter is implemented by engines which punish signers of
+// conflicting blocks. Report is given a block which does not extend the tip.
+type EvidenceReporter interface {
+	Report(bc *Blockchain, b *Block) error
+}
+
+// replay returns snapshot of engine state after block with hash, applying
+// blocks in chain order to the latest cached snapshot of their ancestors,
+// or to genesis. Snapshots of applied blocks are cached by block hash.
+func replay[S any](bc *Blockchain, cache map[string]S, hash []byte, genesis S, apply func(S, *Block) (S, error)) (S, error) {
+	var blocks []*Block
+	snap, ok := cache[string(hash)]
+	for !ok && hash != nil {
+		block := bc.DB.Block(hash)
+		if block == nil {
+			return snap, errors.New("unknown parent block")
+		}
+		blocks = append(blocks, block)
+		hash = block.Header.PrevHash
+		snap, ok = cache[string(hash)]
+	}
+	if !ok {
+		snap = genesis
+	}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		var err error
+		if snap, err = apply(snap, blocks[i]); err != nil {
+			return snap, err
+		}
+		cache[string(blocks[i].Header.Hash)] = snap
+	}
+	return snap, nil
+}
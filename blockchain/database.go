@@ -2,7 +2,9 @@ package blockchain
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
@@ -14,11 +16,22 @@ type Database struct {
 
 const (
 	bcbucket = "blockchain"
-	poolkey  = "pool"
+	chkey    = "change"
+	feekey   = "feestats"
+	lockkey  = "locked"
+	mpbucket = "mempool"
+	mskey    = "multisigs"
+	ptxkey   = "partial"
+	propkey  = "proposals"
+	evkey    = "evidence"
+	synckey  = "sync"
+	peerkey  = "peers"
 	tipkey   = "tip"
 	utxokey  = "utxo"
 	wskey    = "wallets"
 	dbpath   = "data/blockchain.db"
+
+	dbtimeout = 10 * time.Second
 )
 
 func GetDatabase() *Database {
@@ -29,23 +42,30 @@ func GetDatabase() *Database {
 			panic(err)
 		}
 	}
+	LoadParams(paramspath)
+	return OpenDatabase(dbpath)
+}
+
+// OpenDatabase opens the database at path without loading chain params.
+func OpenDatabase(path string) *Database {
 	d := &Database{}
-	db, err := bolt.Open(dbpath, 0600, nil)
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: dbtimeout})
 	if err != nil {
 		panic(err)
 	}
 	d.DB = db
 	err = d.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bcbucket))
-		var err error
-		if b == nil {
-			b, err = tx.CreateBucket([]byte(bcbucket))
+		for _, bucket := range []string{bcbucket, mpbucket} {
+			_, err := tx.CreateBucketIfNotExists([]byte(bucket))
 			if err != nil {
 				return err
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		panic(err)
+	}
 	return d
 }
 
@@ -55,10 +75,7 @@ func (d *Database) Close() {
 
 func (d *Database) Blockchain() *Blockchain {
 	bc := &Blockchain{}
-	pool := d.Pool()
-	if pool != nil {
-		bc.Pool = *pool
-	}
+	bc.Mempool = d.Mempool()
 	bc.Difficulty = difficulty
 	bc.DB = d
 	return bc
@@ -100,6 +117,17 @@ func (d *Database) NextBlock() *Block {
 	return block
 }
 
+func (d *Database) Block(hash []byte) *Block {
+	if hash == nil {
+		return nil
+	}
+	key := d.Key
+	d.Key = hash
+	block := d.NextBlock()
+	d.Key = key
+	return block
+}
+
 func (d *Database) PeekBlock() *Block {
 	key := d.Key
 	block := d.NextBlock()
@@ -129,46 +157,65 @@ func (d *Database) AddBlock(block *Block) {
 	}
 }
 
-func (d *Database) Pool() *Txs {
-	var data []byte
-	err := d.DB.View(func(tx *bolt.Tx) error {
+// SetTip makes block with hash the tip, or empties chain when hash is nil.
+func (d *Database) SetTip(hash []byte) {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bcbucket))
 		if b == nil {
 			return errors.New("bucket does not exist")
 		}
-		data = b.Get([]byte(poolkey))
-		return nil
+		if hash == nil {
+			return b.Delete([]byte(tipkey))
+		}
+		return b.Put([]byte(tipkey), hash)
 	})
 	if err != nil {
 		panic(err)
 	}
-	if data == nil {
-		return nil
+}
+
+func (d *Database) Mempool() *Mempool {
+	mp := NewMempool()
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(mpbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		return b.ForEach(func(k, v []byte) error {
+			mp.insert(string(k), MempoolEntryDeserialize(v))
+			return nil
+		})
+	})
+	if err != nil {
+		panic(err)
 	}
-	return TxsDeserialize(data)
+	return mp
 }
 
-func (d *Database) SetPool(pool *Txs) {
+func (d *Database) AddMempoolEntry(e *MempoolEntry) {
 	err := d.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bcbucket))
+		b := tx.Bucket([]byte(mpbucket))
 		if b == nil {
 			return errors.New("bucket does not exist")
 		}
-		b.Put([]byte(poolkey), pool.Serialize())
-		return nil
+		return b.Put([]byte(fmt.Sprintf("%x", e.Tx.ID())), e.Bytes())
 	})
 	if err != nil {
 		panic(err)
 	}
 }
 
-func (d *Database) CleanPool() {
+func (d *Database) RemoveMempoolEntries(txids []string) {
 	err := d.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bcbucket))
+		b := tx.Bucket([]byte(mpbucket))
 		if b == nil {
 			return errors.New("bucket does not exist")
 		}
-		b.Delete([]byte(poolkey))
+		for _, txid := range txids {
+			if err := b.Delete([]byte(txid)); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -241,3 +288,300 @@ func (d *Database) SetUTXOSet(u *UTXOSet) {
 		panic(err)
 	}
 }
+
+func (d *Database) Multisigs() *Multisigs {
+	var data []byte
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		data = b.Get([]byte(mskey))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if data == nil {
+		return nil
+	}
+	return MultisigsDeserialize(data)
+}
+
+func (d *Database) SetMultisigs(mss *Multisigs) {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		b.Put([]byte(mskey), mss.Serialize())
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (d *Database) PartialTxs() *PartialTxs {
+	var data []byte
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		data = b.Get([]byte(ptxkey))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if data == nil {
+		return nil
+	}
+	return PartialTxsDeserialize(data)
+}
+
+func (d *Database) SetPartialTxs(ps *PartialTxs) {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		b.Put([]byte(ptxkey), ps.Serialize())
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (d *Database) FeeStats() *FeeStats {
+	var data []byte
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		data = b.Get([]byte(feekey))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if data == nil {
+		return nil
+	}
+	return FeeStatsDeserialize(data)
+}
+
+func (d *Database) SetFeeStats(fs *FeeStats) {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		b.Put([]byte(feekey), fs.Serialize())
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (d *Database) LockedOutputs() *LockedOutputs {
+	var data []byte
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		data = b.Get([]byte(lockkey))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if data == nil {
+		return nil
+	}
+	return LockedOutputsDeserialize(data)
+}
+
+func (d *Database) SetLockedOutputs(l *LockedOutputs) {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		b.Put([]byte(lockkey), l.Serialize())
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (d *Database) ChangeOutputs() *ChangeOutputs {
+	var data []byte
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		data = b.Get([]byte(chkey))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if data == nil {
+		return nil
+	}
+	return ChangeOutputsDeserialize(data)
+}
+
+func (d *Database) SetChangeOutputs(c *ChangeOutputs) {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		b.Put([]byte(chkey), c.Serialize())
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (d *Database) Proposals() *Proposals {
+	var data []byte
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		data = b.Get([]byte(propkey))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if data == nil {
+		return nil
+	}
+	return ProposalsDeserialize(data)
+}
+
+func (d *Database) SetProposals(p *Proposals) {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		b.Put([]byte(propkey), p.Serialize())
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (d *Database) Evidence() *Evidence {
+	var data []byte
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		data = b.Get([]byte(evkey))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if data == nil {
+		return nil
+	}
+	return EvidenceDeserialize(data)
+}
+
+func (d *Database) SetEvidence(ev *Evidence) {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		b.Put([]byte(evkey), ev.Serialize())
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (d *Database) SyncStatus() *SyncStatus {
+	var data []byte
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		data = b.Get([]byte(synckey))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if data == nil {
+		return nil
+	}
+	return SyncStatusDeserialize(data)
+}
+
+func (d *Database) SetSyncStatus(s *SyncStatus) {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		b.Put([]byte(synckey), s.Serialize())
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (d *Database) AddrMan() *AddrMan {
+	var data []byte
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		data = b.Get([]byte(peerkey))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if data == nil {
+		return nil
+	}
+	return AddrManDeserialize(data)
+}
+
+func (d *Database) SetAddrMan(am *AddrMan) {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bcbucket))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		b.Put([]byte(peerkey), am.Serialize())
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
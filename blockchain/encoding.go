@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const hashSize = 32
+
+var errTruncated = errors.New("unexpected end of data")
+
+type Encoder struct {
+	buf []byte
+}
+
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+func (e *Encoder) Write(data []byte) {
+	e.buf = append(e.buf, data...)
+}
+
+func (e *Encoder) WriteVarInt(n uint64) {
+	switch {
+	case n < 0xfd:
+		e.buf = append(e.buf, byte(n))
+	case n <= 0xffff:
+		e.buf = append(e.buf, 0xfd)
+		e.buf = binary.LittleEndian.AppendUint16(e.buf, uint16(n))
+	case n <= 0xffffffff:
+		e.buf = append(e.buf, 0xfe)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, 0xff)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, n)
+	}
+}
+
+func (e *Encoder) WriteUint32(n uint32) {
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, n)
+}
+
+func (e *Encoder) WriteInt64(n int64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, uint64(n))
+}
+
+func (e *Encoder) WriteUint64(n uint64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, n)
+}
+
+func (e *Encoder) WriteHash(hash []byte) {
+	fixed := make([]byte, hashSize)
+	copy(fixed, hash)
+	e.buf = append(e.buf, fixed...)
+}
+
+func (e *Encoder) WriteBytes(data []byte) {
+	e.WriteVarInt(uint64(len(data)))
+	e.buf = append(e.buf, data...)
+}
+
+type Decoder struct {
+	data []byte
+	err  error
+}
+
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{data: data}
+}
+
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+func (d *Decoder) Remaining() int {
+	return len(d.data)
+}
+
+func (d *Decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.data = nil
+}
+
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.data) {
+		d.fail(errTruncated)
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *Decoder) ReadVarInt() uint64 {
+	prefix := d.next(1)
+	if prefix == nil {
+		return 0
+	}
+	var n, min uint64
+	switch prefix[0] {
+	case 0xfd:
+		if b := d.next(2); b != nil {
+			n, min = uint64(binary.LittleEndian.Uint16(b)), 0xfd
+		}
+	case 0xfe:
+		if b := d.next(4); b != nil {
+			n, min = uint64(binary.LittleEndian.Uint32(b)), 0x10000
+		}
+	case 0xff:
+		if b := d.next(8); b != nil {
+			n, min = binary.LittleEndian.Uint64(b), 0x100000000
+		}
+	default:
+		return uint64(prefix[0])
+	}
+	if d.err == nil && n < min {
+		d.fail(errors.New("non-canonical variable length integer"))
+		return 0
+	}
+	return n
+}
+
+func (d *Decoder) ReadUint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *Decoder) ReadInt64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+func (d *Decoder) ReadUint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (d *Decoder) ReadHash() []byte {
+	b := d.next(hashSize)
+	if b == nil || bytes.Equal(b, make([]byte, hashSize)) {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func (d *Decoder) ReadBytes() []byte {
+	n := d.ReadCount(1)
+	if n == 0 {
+		return nil
+	}
+	return append([]byte(nil), d.next(n)...)
+}
+
+// ReadCount reads a length prefix and rejects it early when the remaining
+// data cannot hold that many items of at least minSize bytes each.
+func (d *Decoder) ReadCount(minSize int) int {
+	n := d.ReadVarInt()
+	if d.err != nil {
+		return 0
+	}
+	if n > uint64(len(d.data)/minSize) {
+		d.fail(errTruncated)
+		return 0
+	}
+	return int(n)
+}
@@ -0,0 +1,135 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type testVector struct {
+	Title  string
+	Data   []byte
+	Fields map[string]string
+}
+
+// readTestVectors reads code blocks of Test Vectors section of encoding.md,
+// the encoding in hex followed by "name: value" lines.
+func readTestVectors(t *testing.T) []testVector {
+	doc, err := os.ReadFile("../encoding.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, section, ok := strings.Cut(string(doc), "## Test Vectors")
+	if !ok {
+		t.Fatal("encoding.md has no test vectors")
+	}
+	var vectors []testVector
+	title := ""
+	blocks := strings.Split(section, "```")
+	for i, block := range blocks {
+		if i%2 == 0 {
+			lines := strings.Split(strings.TrimSpace(block), "\n")
+			title = lines[len(lines)-1]
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		data, err := hex.DecodeString(lines[0])
+		if err != nil {
+			t.Fatalf("%v: %v", title, err)
+		}
+		v := testVector{title, data, make(map[string]string)}
+		for _, line := range lines[1:] {
+			name, value, _ := strings.Cut(line, ": ")
+			v.Fields[name] = value
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors
+}
+
+// vectorTest decodes and re-encodes vectors with title prefix, and checks
+// hashes given in their fields.
+type vectorTest struct {
+	prefix string
+	decode func(data []byte) (encoded []byte, err error)
+	check  func(data []byte, fields map[string]string) error
+}
+
+func TestEncodingVectors(t *testing.T) {
+	tests := []vectorTest{
+		{"Transaction", func(data []byte) ([]byte, error) {
+			tx, err := DecodeTx(data)
+			if err != nil {
+				return nil, err
+			}
+			return tx.Bytes(), nil
+		}, func(data []byte, fields map[string]string) error {
+			tx := TxDeserialize(data)
+			if id := fmt.Sprintf("%x", tx.ID()); id != fields["id"] {
+				return fmt.Errorf("id is %v", id)
+			}
+			return nil
+		}},
+		{"Block header", func(data []byte) ([]byte, error) {
+			h, err := DecodeBlockHeader(data)
+			if err != nil {
+				return nil, err
+			}
+			return h.Bytes(), nil
+		}, func(data []byte, fields map[string]string) error {
+			h, _ := DecodeBlockHeader(data)
+			if root := fmt.Sprintf("%x", h.MerkleRoot); root != fields["merkle root"] {
+				return fmt.Errorf("merkle root is %v", root)
+			}
+			if hash := fmt.Sprintf("%x", h.Hash); hash != fields["hash"] {
+				return fmt.Errorf("hash is %v", hash)
+			}
+			return nil
+		}},
+		{"Block containing", func(data []byte) ([]byte, error) {
+			b, err := DecodeBlock(data)
+			if err != nil {
+				return nil, err
+			}
+			return b.Bytes(), nil
+		}, func(data []byte, fields map[string]string) error {
+			b := BlockDeserialize(data)
+			if !bytes.Equal(b.Txs.MerkleRoot(), b.Header.MerkleRoot) {
+				return fmt.Errorf("merkle root of transactions is %x", b.Txs.MerkleRoot())
+			}
+			return nil
+		}},
+	}
+	vectors := readTestVectors(t)
+	if len(vectors) != 4 {
+		t.Fatalf("read %v test vectors, want 4", len(vectors))
+	}
+	for _, v := range vectors {
+		idx := slices.IndexFunc(tests, func(test vectorTest) bool {
+			return strings.HasPrefix(v.Title, test.prefix)
+		})
+		if idx < 0 {
+			t.Errorf("no test of vector %v", v.Title)
+			continue
+		}
+		test := tests[idx]
+		encoded, err := test.decode(v.Data)
+		if err != nil {
+			t.Errorf("%v failed to decode: %v", v.Title, err)
+			continue
+		}
+		if !bytes.Equal(encoded, v.Data) {
+			t.Errorf("%v is re-encoded as %x", v.Title, encoded)
+		}
+		if err := test.check(v.Data, v.Fields); err != nil {
+			t.Errorf("%v %v", v.Title, err)
+		}
+		if _, err := test.decode(append(v.Data, 0)); err == nil {
+			t.Errorf("%v is decoded with trailing data", v.Title)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	feeBuckets       = 32
+	minBucketFeeRate = 1000
+	maxConfirmTarget = 48
+	feeDecay         = 0.998
+	minFeeSamples    = 4
+	feeSuccessRatio  = 0.85
+)
+
+// FeeStats tracks in how many blocks mempool transactions of each fee rate
+// bucket were confirmed, and how long those which left mempool unconfirmed
+// had waited. Fee rates are in units per 1000 bytes, bucket 0 holds rates
+// below minBucketFeeRate and every next bucket doubles the rate.
+type FeeStats struct {
+	Height    int
+	Total     []float64
+	FeeSum    []float64
+	Confirmed [][]float64
+	Failed    [][]float64
+}
+
+func NewFeeStats() *FeeStats {
+	fs := &FeeStats{Height: -1}
+	fs.Total = make([]float64, feeBuckets)
+	fs.FeeSum = make([]float64, feeBuckets)
+	fs.Confirmed = make([][]float64, feeBuckets)
+	fs.Failed = make([][]float64, feeBuckets)
+	for b := range fs.Confirmed {
+		fs.Confirmed[b] = make([]float64, maxConfirmTarget)
+		fs.Failed[b] = make([]float64, maxConfirmTarget)
+	}
+	return fs
+}
+
+func feeBucket(rate Amount) int {
+	bucket := 0
+	for limit := Amount(minBucketFeeRate); rate >= limit && bucket < feeBuckets-1; limit *= 2 {
+		bucket++
+	}
+	return bucket
+}
+
+func (fs *FeeStats) ProcessBlock(height int, entries []*MempoolEntry) {
+	if height <= fs.Height {
+		return
+	}
+	fs.Height = height
+	for b := range fs.Total {
+		fs.Total[b] *= feeDecay
+		fs.FeeSum[b] *= feeDecay
+		for t := range fs.Confirmed[b] {
+			fs.Confirmed[b][t] *= feeDecay
+			fs.Failed[b][t] *= feeDecay
+		}
+	}
+	for _, e := range entries {
+		rate := e.Fee * 1000 / Amount(e.Size)
+		blocks := max(height-e.Height+1, 1)
+		b := feeBucket(rate)
+		fs.Total[b]++
+		fs.FeeSum[b] += float64(rate)
+		for t := blocks; t <= maxConfirmTarget; t++ {
+			fs.Confirmed[b][t-1]++
+		}
+	}
+}
+
+// ProcessFailed records entries which left mempool unconfirmed at height,
+// being evicted, replaced, expired or conflicting with a block. They count as
+// failures for every target which they had waited for.
+func (fs *FeeStats) ProcessFailed(height int, entries []*MempoolEntry) {
+	for _, e := range entries {
+		blocks := min(height-e.Height+1, maxConfirmTarget)
+		b := feeBucket(e.Fee * 1000 / Amount(e.Size))
+		for t := 1; t <= blocks; t++ {
+			fs.Failed[b][t-1]++
+		}
+	}
+}
+
+// Estimate returns the lowest fee rate per 1000 bytes at which enough of
+// tracked transactions were confirmed within target blocks. Failed entries
+// and pending mempool entries which have waited at height for target blocks
+// count against the fee rate of their bucket.
+func (fs *FeeStats) Estimate(target, height int, pending []*MempoolEntry) (Amount, error) {
+	if target < 1 {
+		return 0, errors.New("target must be at least 1 block")
+	}
+	target = min(target, maxConfirmTarget)
+	waiting := make([]float64, feeBuckets)
+	for _, e := range pending {
+		if height-e.Height+1 >= target {
+			waiting[feeBucket(e.Fee*1000/Amount(e.Size))]++
+		}
+	}
+	estimate := Amount(-1)
+	var total, confirmed, count, feeSum float64
+	for b := feeBuckets - 1; b >= 0; b-- {
+		total += fs.Total[b] + fs.Failed[b][target-1] + waiting[b]
+		confirmed += fs.Confirmed[b][target-1]
+		count += fs.Total[b]
+		feeSum += fs.FeeSum[b]
+		if total < minFeeSamples {
+			continue
+		}
+		if confirmed/total < feeSuccessRatio {
+			break
+		}
+		estimate = Amount(math.Round(feeSum / count))
+		total, confirmed, count, feeSum = 0, 0, 0, 0
+	}
+	if estimate < 0 {
+		return 0, errors.New("insufficient data to estimate fee")
+	}
+	return max(estimate, params.MinRelayFee), nil
+}
+
+func (fs *FeeStats) Serialize() []byte {
+	e := &Encoder{}
+	e.WriteUint32(uint32(fs.Height + 1))
+	e.WriteVarInt(feeBuckets)
+	e.WriteVarInt(maxConfirmTarget)
+	for b := 0; b < feeBuckets; b++ {
+		e.WriteUint64(math.Float64bits(fs.Total[b]))
+		e.WriteUint64(math.Float64bits(fs.FeeSum[b]))
+		for t := 0; t < maxConfirmTarget; t++ {
+			e.WriteUint64(math.Float64bits(fs.Confirmed[b][t]))
+			e.WriteUint64(math.Float64bits(fs.Failed[b][t]))
+		}
+	}
+	return e.Bytes()
+}
+
+// FeeStatsDeserialize discards statistics stored with a different layout.
+func FeeStatsDeserialize(data []byte) *FeeStats {
+	fs := NewFeeStats()
+	d := NewDecoder(data)
+	fs.Height = int(d.ReadUint32()) - 1
+	if d.ReadVarInt() != feeBuckets || d.ReadVarInt() != maxConfirmTarget {
+		return NewFeeStats()
+	}
+	for b := 0; b < feeBuckets; b++ {
+		fs.Total[b] = math.Float64frombits(d.ReadUint64())
+		fs.FeeSum[b] = math.Float64frombits(d.ReadUint64())
+		for t := 0; t < maxConfirmTarget; t++ {
+			fs.Confirmed[b][t] = math.Float64frombits(d.ReadUint64())
+			fs.Failed[b][t] = math.Float64frombits(d.ReadUint64())
+		}
+	}
+	if d.Err() != nil || d.Remaining() != 0 {
+		return NewFeeStats()
+	}
+	return fs
+}
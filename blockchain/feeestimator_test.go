@@ -0,0 +1,43 @@
+package blockchain
+
+import "testing"
+
+func feeEntries(n int, rate Amount, height int) []*MempoolEntry {
+	entries := make([]*MempoolEntry, n)
+	for i := range entries {
+		entries[i] = &MempoolEntry{Fee: rate, Size: 1000, Height: height}
+	}
+	return entries
+}
+
+func TestEstimateFeeFailures(t *testing.T) {
+	const low, high = 4000, 64000
+	newStats := func() *FeeStats {
+		fs := NewFeeStats()
+		fs.ProcessBlock(10, append(feeEntries(10, low, 10), feeEntries(10, high, 10)...))
+		return fs
+	}
+	if rate, err := newStats().Estimate(1, 10, nil); err != nil || rate != low {
+		t.Fatalf("estimate is %v, %v, want %v", rate, err, low)
+	}
+
+	fs := newStats()
+	fs.ProcessFailed(12, feeEntries(10, low, 10))
+	if rate, err := fs.Estimate(1, 12, nil); err != nil || rate != high {
+		t.Errorf("estimate with failed entries is %v, %v, want %v", rate, err, high)
+	}
+	if rate, err := FeeStatsDeserialize(fs.Serialize()).Estimate(1, 12, nil); err != nil || rate != high {
+		t.Errorf("estimate of deserialized stats is %v, %v, want %v", rate, err, high)
+	}
+	if rate, err := fs.Estimate(4, 12, nil); err != nil || rate != low {
+		t.Errorf("entries which waited 3 blocks fail target 4, estimate is %v, %v", rate, err)
+	}
+
+	pending := feeEntries(10, low, 11)
+	if rate, err := newStats().Estimate(2, 12, pending); err != nil || rate != high {
+		t.Errorf("estimate with waiting entries is %v, %v, want %v", rate, err, high)
+	}
+	if rate, err := newStats().Estimate(3, 12, pending); err != nil || rate != low {
+		t.Errorf("entries which waited 2 blocks fail target 3, estimate is %v, %v", rate, err)
+	}
+}
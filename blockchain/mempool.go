@@ -0,0 +1,482 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const maxReplacements = 100
+
+type MempoolEntry struct {
+	Tx     *Tx
+	Fee    Amount
+	Size   int
+	Time   int
+	Height int
+}
+
+func (e *MempoolEntry) FeeRate() float64 {
+	return float64(e.Fee) / float64(e.Size)
+}
+
+func (e *MempoolEntry) Bytes() []byte {
+	enc := &Encoder{}
+	enc.Write(e.Tx.Bytes())
+	enc.WriteInt64(int64(e.Fee))
+	enc.WriteInt64(int64(e.Time))
+	enc.WriteUint32(uint32(e.Height))
+	return enc.Bytes()
+}
+
+func MempoolEntryDeserialize(data []byte) *MempoolEntry {
+	d := NewDecoder(data)
+	e := &MempoolEntry{}
+	e.Tx = d.ReadTx()
+	e.Fee = Amount(d.ReadInt64())
+	e.Time = int(d.ReadInt64())
+	e.Height = int(d.ReadUint32())
+	if d.Err() != nil {
+		panic(d.Err())
+	}
+	e.Size = len(e.Tx.Bytes())
+	return e
+}
+
+// lowerFeeRate compares fee rates without floating point rounding.
+func lowerFeeRate(fee Amount, size int, other Amount, otherSize int) bool {
+	return int64(fee)*int64(otherSize) < int64(other)*int64(size)
+}
+
+type Mempool struct {
+	Entries map[string]*MempoolEntry
+	spent   map[string]string
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{make(map[string]*MempoolEntry), make(map[string]string)}
+}
+
+func outpoint(txHash []byte, idx int) string {
+	return fmt.Sprintf("%x:%d", txHash, idx)
+}
+
+func (mp *Mempool) Size() int {
+	size := 0
+	for _, e := range mp.Entries {
+		size += e.Size
+	}
+	return size
+}
+
+func (mp *Mempool) Fees() Amount {
+	fees := Amount(0)
+	for _, e := range mp.Entries {
+		fees += e.Fee
+	}
+	return fees
+}
+
+func (mp *Mempool) Txs() Txs {
+	txs := make(Txs, 0, len(mp.Entries))
+	for _, txid := range mp.sorted() {
+		txs = append(txs, mp.Entries[txid].Tx)
+	}
+	return txs
+}
+
+// sorted orders entries by arrival time, placing parents before children.
+func (mp *Mempool) sorted() []string {
+	txids := make([]string, 0, len(mp.Entries))
+	for txid := range mp.Entries {
+		txids = append(txids, txid)
+	}
+	sort.Slice(txids, func(i, j int) bool {
+		a, b := mp.Entries[txids[i]], mp.Entries[txids[j]]
+		if a.Time != b.Time {
+			return a.Time < b.Time
+		}
+		return txids[i] < txids[j]
+	})
+	ordered := make([]string, 0, len(txids))
+	visited := make(map[string]bool)
+	var visit func(txid string)
+	visit = func(txid string) {
+		if visited[txid] {
+			return
+		}
+		visited[txid] = true
+		for _, parent := range mp.Parents(txid) {
+			visit(parent)
+		}
+		ordered = append(ordered, txid)
+	}
+	for _, txid := range txids {
+		visit(txid)
+	}
+	return ordered
+}
+
+func (mp *Mempool) Parents(txid string) []string {
+	parents := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, in := range mp.Entries[txid].Tx.TxIn {
+		parent := hex.EncodeToString(in.TxOutHash)
+		if _, ok := mp.Entries[parent]; ok && !seen[parent] {
+			seen[parent] = true
+			parents = append(parents, parent)
+		}
+	}
+	return parents
+}
+
+func (mp *Mempool) Children(txid string) []string {
+	children := make([]string, 0)
+	seen := make(map[string]bool)
+	for idx := range mp.Entries[txid].Tx.TxOut {
+		txHash, _ := hex.DecodeString(txid)
+		child, ok := mp.spent[outpoint(txHash, idx)]
+		if ok && !seen[child] {
+			seen[child] = true
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+func (mp *Mempool) Ancestors(txid string) []string {
+	return mp.walk(txid, mp.Parents)
+}
+
+func (mp *Mempool) Descendants(txid string) []string {
+	return mp.walk(txid, mp.Children)
+}
+
+func (mp *Mempool) walk(txid string, next func(string) []string) []string {
+	result := make([]string, 0)
+	seen := map[string]bool{txid: true}
+	queue := next(txid)
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if seen[cur] {
+			continue
+		}
+		seen[cur] = true
+		result = append(result, cur)
+		queue = append(queue, next(cur)...)
+	}
+	return result
+}
+
+// Check validates tx against the UTXO set and mempool and returns its fee
+// with mempool transactions spending the same outputs.
+func (mp *Mempool) Check(tx *Tx, u *UTXOSet, height int) (Amount, []string, error) {
+	if len(tx.TxIn) == 0 || len(tx.TxOut) == 0 || tx.IsCoinBase() {
+		return 0, nil, errors.New("transaction has no inputs or outputs")
+	}
+	if _, ok := mp.Entries[fmt.Sprintf("%x", tx.ID())]; ok {
+		return 0, nil, errors.New("transaction is already in mempool")
+	}
+	if !tx.Final(height, int(time.Now().Unix())) {
+		return 0, nil, errors.New("transaction is not final")
+	}
+	outValue, err := tx.CheckAmounts()
+	if err != nil {
+		return 0, nil, err
+	}
+	for idx, out := range tx.TxOut {
+		if out.Value < DustThreshold {
+			return 0, nil, fmt.Errorf("output %v is below dust threshold", idx)
+		}
+	}
+	inValue := Amount(0)
+	seen := make(map[string]bool)
+	conflicts := make([]string, 0)
+	for idx, in := range tx.TxIn {
+		op := outpoint(in.TxOutHash, in.TxOutIndex)
+		if seen[op] {
+			return 0, nil, fmt.Errorf("input %v spends the same output twice", idx)
+		}
+		seen[op] = true
+		if spender, ok := mp.spent[op]; ok {
+			conflicts = append(conflicts, spender)
+		}
+		prevOut, err := mp.prevOut(in, u, height)
+		if err != nil {
+			return 0, nil, fmt.Errorf("input %v %v", idx, err)
+		}
+		if !tx.VerifyInput(idx, prevOut) {
+			return 0, nil, fmt.Errorf("input %v is invalid", idx)
+		}
+		if inValue, err = SumAmounts(inValue, prevOut.Value); err != nil {
+			return 0, nil, err
+		}
+	}
+	if inValue < outValue {
+		return 0, nil, errors.New("outputs exceed inputs")
+	}
+	return inValue - outValue, conflicts, nil
+}
+
+func (mp *Mempool) prevOut(in *TxIn, u *UTXOSet, height int) (*TxOut, error) {
+	if parent, ok := mp.Entries[hex.EncodeToString(in.TxOutHash)]; ok {
+		if in.TxOutIndex < 0 || in.TxOutIndex >= len(parent.Tx.TxOut) {
+			return nil, errors.New("spends unknown or spent output")
+		}
+		return parent.Tx.TxOut[in.TxOutIndex], nil
+	}
+	utxo := u.UTXO(in.TxOutHash, in.TxOutIndex)
+	if utxo == nil {
+		return nil, errors.New("spends unknown or spent output")
+	}
+	if !utxo.Mature(height) {
+		return nil, errors.New("spends immature coinbase")
+	}
+	return utxo.TxOut, nil
+}
+
+func (mp *Mempool) Add(tx *Tx, u *UTXOSet, height int) (*MempoolEntry, []string, error) {
+	fee, conflicts, err := mp.Check(tx, u, height)
+	if err != nil {
+		return nil, nil, err
+	}
+	e := &MempoolEntry{tx, fee, len(tx.Bytes()), int(time.Now().Unix()), height}
+	if e.Size > params.MempoolMaxSize {
+		return nil, nil, errors.New("transaction is larger than mempool")
+	}
+	if lowerFeeRate(e.Fee, e.Size, params.MinRelayFee, 1000) {
+		return nil, nil, errors.New("fee rate is below minimum relay fee")
+	}
+	replaced, err := mp.replaced(tx, e, conflicts)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range replaced {
+		mp.remove(id)
+	}
+	txid := fmt.Sprintf("%x", tx.ID())
+	mp.insert(txid, e)
+	if len(mp.Ancestors(txid)) > params.MempoolMaxAncestors {
+		mp.remove(txid)
+		return nil, nil, errors.New("too many unconfirmed ancestors")
+	}
+	evicted := mp.trim(txid)
+	for _, id := range evicted {
+		if id == txid {
+			return nil, append(replaced, evicted...), errors.New("mempool is full and fee rate is too low")
+		}
+	}
+	return e, append(replaced, evicted...), nil
+}
+
+// replaced applies replace-by-fee rules to the conflicting transactions and
+// returns them with their descendants, which are evicted by the replacement.
+func (mp *Mempool) replaced(tx *Tx, e *MempoolEntry, conflicts []string) ([]string, error) {
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+	replaced := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, txid := range conflicts {
+		c := mp.Entries[txid]
+		if !c.Tx.SignalsRBF() {
+			return nil, fmt.Errorf("conflicting transaction %v is not replaceable", txid)
+		}
+		if !lowerFeeRate(c.Fee, c.Size, e.Fee, e.Size) {
+			return nil, fmt.Errorf("fee rate is not higher than of conflicting transaction %v", txid)
+		}
+		for _, id := range append(mp.Descendants(txid), txid) {
+			if !seen[id] {
+				seen[id] = true
+				replaced = append(replaced, id)
+			}
+		}
+	}
+	if len(replaced) > maxReplacements {
+		return nil, fmt.Errorf("replacement evicts more than %v transactions", maxReplacements)
+	}
+	for _, in := range tx.TxIn {
+		if seen[hex.EncodeToString(in.TxOutHash)] {
+			return nil, errors.New("replacement spends outputs of replaced transaction")
+		}
+	}
+	fees := Amount(0)
+	for _, id := range replaced {
+		fees += mp.Entries[id].Fee
+	}
+	if e.Fee < fees+incrementalFee(e.Size) {
+		return nil, fmt.Errorf("fee %v does not pay for replaced fees %v and relay", e.Fee, fees)
+	}
+	return replaced, nil
+}
+
+// ReplacementFee returns the smallest fee of a transaction of given size
+// which replaces txid and its descendants.
+func (mp *Mempool) ReplacementFee(txid string, size int) Amount {
+	fee := incrementalFee(size)
+	for _, id := range append(mp.Descendants(txid), txid) {
+		fee += mp.Entries[id].Fee
+	}
+	e := mp.Entries[txid]
+	if higher := e.Fee*Amount(size)/Amount(e.Size) + 1; fee < higher {
+		fee = higher
+	}
+	return fee
+}
+
+func incrementalFee(size int) Amount {
+	fee := params.IncrementalRelayFee * Amount(size) / 1000
+	if fee < 1 {
+		fee = 1
+	}
+	return fee
+}
+
+func (mp *Mempool) insert(txid string, e *MempoolEntry) {
+	mp.Entries[txid] = e
+	for _, in := range e.Tx.TxIn {
+		mp.spent[outpoint(in.TxOutHash, in.TxOutIndex)] = txid
+	}
+}
+
+func (mp *Mempool) remove(txid string) {
+	e, ok := mp.Entries[txid]
+	if !ok {
+		return
+	}
+	for _, in := range e.Tx.TxIn {
+		delete(mp.spent, outpoint(in.TxOutHash, in.TxOutIndex))
+	}
+	delete(mp.Entries, txid)
+}
+
+func (mp *Mempool) Remove(txid string) []string {
+	if _, ok := mp.Entries[txid]; !ok {
+		return nil
+	}
+	removed := append(mp.Descendants(txid), txid)
+	for _, id := range removed {
+		mp.remove(id)
+	}
+	return removed
+}
+
+// trim evicts entries with the lowest fee rate until mempool fits its size
+// limit, preferring the newest entry among equal fee rates.
+func (mp *Mempool) trim(newest string) []string {
+	evicted := make([]string, 0)
+	for mp.Size() > params.MempoolMaxSize {
+		worst := newest
+		if _, ok := mp.Entries[worst]; !ok {
+			worst = ""
+		}
+		for txid, e := range mp.Entries {
+			w := mp.Entries[worst]
+			if worst == "" || lowerFeeRate(e.Fee, e.Size, w.Fee, w.Size) {
+				worst = txid
+			}
+		}
+		evicted = append(evicted, mp.Remove(worst)...)
+	}
+	return evicted
+}
+
+func (mp *Mempool) Expire(now int) []string {
+	expired := make([]string, 0)
+	for _, txid := range mp.sorted() {
+		if e, ok := mp.Entries[txid]; ok && now-e.Time > params.MempoolExpiry {
+			expired = append(expired, mp.Remove(txid)...)
+		}
+	}
+	return expired
+}
+
+func (mp *Mempool) RemoveForBlock(txs Txs) []string {
+	removed := make([]string, 0)
+	for _, tx := range txs {
+		txid := fmt.Sprintf("%x", tx.ID())
+		if _, ok := mp.Entries[txid]; ok {
+			mp.remove(txid)
+			removed = append(removed, txid)
+			continue
+		}
+		for _, in := range tx.TxIn {
+			if spender, ok := mp.spent[outpoint(in.TxOutHash, in.TxOutIndex)]; ok {
+				removed = append(removed, mp.Remove(spender)...)
+			}
+		}
+	}
+	return removed
+}
+
+// BlockTxs selects transactions by fee rate of their packages with unconfirmed
+// ancestors, so that a child paying a high fee also confirms its parents.
+func (mp *Mempool) BlockTxs() Txs {
+	txids := mp.sorted()
+	included := make(map[string]bool)
+	txs := make(Txs, 0, len(txids))
+	size := 0
+	for {
+		var best []string
+		var bestFee Amount
+		bestSize := 0
+		for _, txid := range txids {
+			if included[txid] {
+				continue
+			}
+			pkg := mp.ancestorPackage(txid, txids, included)
+			fee, pkgSize := Amount(0), 0
+			for _, id := range pkg {
+				fee += mp.Entries[id].Fee
+				pkgSize += mp.Entries[id].Size
+			}
+			if size+pkgSize > params.MaxBlockSize {
+				continue
+			}
+			if best == nil || lowerFeeRate(bestFee, bestSize, fee, pkgSize) {
+				best, bestFee, bestSize = pkg, fee, pkgSize
+			}
+		}
+		if best == nil {
+			return txs
+		}
+		for _, txid := range best {
+			included[txid] = true
+			txs = append(txs, mp.Entries[txid].Tx)
+		}
+		size += bestSize
+	}
+}
+
+// ancestorPackage returns txid with its ancestors which are not excluded
+// in the given topological order.
+func (mp *Mempool) ancestorPackage(txid string, order []string, excluded map[string]bool) []string {
+	ancestors := make(map[string]bool)
+	for _, id := range mp.Ancestors(txid) {
+		ancestors[id] = true
+	}
+	pkg := make([]string, 0)
+	for _, id := range order {
+		if (id == txid || ancestors[id]) && !excluded[id] {
+			pkg = append(pkg, id)
+		}
+	}
+	return pkg
+}
+
+func (mp *Mempool) View(u *UTXOSet) *UTXOSet {
+	view := make(UTXOSet)
+	for txHashStr, outs := range *u {
+		view[txHashStr] = make(map[int]*UTXO)
+		for idx, utxo := range outs {
+			view[txHashStr][idx] = utxo
+		}
+	}
+	for _, txid := range mp.sorted() {
+		view.Update(mp.Entries[txid].Tx, -1)
+	}
+	return &view
+}
@@ -0,0 +1,39 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMempoolExpiry(t *testing.T) {
+	p := params
+	p.Consensus, p.CoinbaseMaturity = "pow", 1
+	bc := testChain(t, p)
+	alice, bob := newTestWallet(), newTestWallet()
+	u := make(UTXOSet)
+	for range 3 {
+		if _, err := bc.Mine(context.Background(), alice, &u, NewMiner(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old, _, err := bc.Send(alice, bob.LockScript(), Coin, 0, &u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := bc.Mempool.Entries[fmt.Sprintf("%x", old.ID())]
+	e.Time = int(time.Now().Unix()) - params.MempoolExpiry - 1
+	bc.DB.AddMempoolEntry(e)
+
+	bc = bc.DB.Blockchain()
+	if bc.Mempool.Entries[fmt.Sprintf("%x", old.ID())] == nil {
+		t.Fatal("reading chain expires mempool entry")
+	}
+	if _, _, err := bc.Send(alice, bob.LockScript(), Coin, 0, &u, nil); err != nil {
+		t.Fatal(err)
+	}
+	if bc.DB.Blockchain().Mempool.Entries[fmt.Sprintf("%x", old.ID())] != nil {
+		t.Error("expired entry stays in mempool after a transaction is admitted")
+	}
+}
@@ -0,0 +1,225 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxNonce bounds nonce space of a block template, after which miner
+	// rolls the coinbase extra nonce and timestamp.
+	maxNonce    = math.MaxUint32
+	nonceOffset = 80
+	hashBatch   = 1 << 12
+)
+
+// Miner hashes with PoW, or with proof of work of chain params when it is nil.
+// Engines which sign blocks sign them with Signer.
+type Miner struct {
+	Threads  int
+	Interval time.Duration
+	PoW      PoW
+	Signer   *Wallet
+	// Relay exchanges messages of BFT validator Signer with peers.
+	Relay  *BFTRelay
+	hashes atomic.Uint64
+}
+
+func NewMiner(threads int) *Miner {
+	if threads < 1 {
+		threads = runtime.NumCPU()
+	}
+	return &Miner{Threads: threads, Interval: 5 * time.Second}
+}
+
+func Target(difficulty int) []byte {
+	target := new(big.Int).Lsh(big.NewInt(1), uint(256-difficulty))
+	return target.FillBytes(make([]byte, hashSize))
+}
+
+func (m *Miner) Hashes() uint64 {
+	return m.hashes.Load()
+}
+
+// Mine searches nonce space of the block in parallel until its hash is below
+// target of difficulty or ctx is cancelled.
+func (m *Miner) Mine(ctx context.Context, b *Block, difficulty int) (*Block, error) {
+	target := Target(difficulty)
+	if m.PoW == nil {
+		m.PoW = params.ProofOfWork()
+	}
+	reportCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go m.report(reportCtx)
+	for extraNonce := 1; ; extraNonce++ {
+		nonce, ok := m.search(ctx, b.Header, target)
+		if ok {
+			b.Header.Nonce = nonce
+			b.Header.Hash = b.Header.ComputeHash()
+			return b, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		b.roll(extraNonce)
+	}
+}
+
+func (m *Miner) search(ctx context.Context, h BlockHeader, target []byte) (int, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan int, m.Threads)
+	var wg sync.WaitGroup
+	for start := 0; start < m.Threads; start++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			data := h.Bytes()
+			count := 0
+			defer func() { m.hashes.Add(uint64(count % hashBatch)) }()
+			for nonce := start; nonce <= maxNonce; nonce += m.Threads {
+				binary.LittleEndian.PutUint64(data[nonceOffset:], uint64(nonce))
+				if bytes.Compare(m.PoW.Hash(data), target) < 0 {
+					found <- nonce
+					cancel()
+					return
+				}
+				if count++; count%hashBatch == 0 {
+					m.hashes.Add(hashBatch)
+					if ctx.Err() != nil {
+						return
+					}
+				}
+			}
+		}(start)
+	}
+	wg.Wait()
+	close(found)
+	nonce, ok := <-found
+	return nonce, ok
+}
+
+// Benchmark returns how many hashes per second miner computes over d.
+func (m *Miner) Benchmark(d time.Duration) float64 {
+	if m.PoW == nil {
+		m.PoW = params.ProofOfWork()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	hashes, since := m.Hashes(), time.Now()
+	m.search(ctx, BlockHeader{Version: blockVersion}, make([]byte, hashSize))
+	return float64(m.Hashes()-hashes) / time.Since(since).Seconds()
+}
+
+func (m *Miner) report(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	last, since := m.Hashes(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			hashes := m.Hashes()
+			rate := float64(hashes-last) / now.Sub(since).Seconds()
+			fmt.Printf("Hashrate: %.2f kH/s\n", rate/1000)
+			last, since = hashes, now
+		}
+	}
+}
+
+// roll changes the block template once its nonce space is exhausted: extra
+// nonce is appended to the coinbase script and timestamp is moved forward.
+func (b *Block) roll(extraNonce int) {
+	now := int(time.Now().Unix())
+	if len(b.Txs) == 0 || !b.Txs[0].IsCoinBase() {
+		b.Header.Timestamp = max(now, b.Header.Timestamp+1)
+		return
+	}
+	coinbase := *b.Txs[0]
+	in := *coinbase.TxIn[0]
+	in.Script = Script{}.AddInt(int64(b.Header.Height)).AddInt(int64(extraNonce))
+	coinbase.TxIn = []*TxIn{&in}
+	b.Txs = append(Txs{&coinbase}, b.Txs[1:]...)
+	b.Header.MerkleRoot = b.Txs.MerkleRoot()
+	b.Header.Timestamp = max(now, b.Header.Timestamp)
+}
+
+// MineContinuous mines blocks paying to lock until ctx is cancelled. Database
+// is opened only to build a block template, to poll for a new tip or mempool
+// transactions, which restart the work, and to submit a mined block.
+func MineContinuous(ctx context.Context, lock Script, m *Miner) {
+	for ctx.Err() == nil {
+		db := GetDatabase()
+		bc := db.Blockchain()
+		engine := params.Engine()
+		block, state := bc.BlockTemplate(lock), workState(bc)
+		err := engine.Prepare(bc, block, m)
+		db.Close()
+		roundCtx, cancel := context.WithCancel(ctx)
+		go watchWork(roundCtx, cancel, state)
+		if err != nil {
+			fmt.Printf("Waiting for new tip: %v\n", err)
+			<-roundCtx.Done()
+			cancel()
+			continue
+		}
+		fmt.Printf("Mining block %v with %v transactions\n", block.Header.Height, len(block.Txs))
+		block, err = engine.Seal(roundCtx, block, m)
+		cancel()
+		if err != nil {
+			continue
+		}
+		db = GetDatabase()
+		bc = db.Blockchain()
+		u := db.UTXOSet()
+		if u == nil {
+			u = new(UTXOSet)
+			*u = make(UTXOSet)
+			u.Index(bc)
+		}
+		if err := bc.SubmitBlock(block, u); err != nil {
+			fmt.Printf("Failed to Submit Block: %v\n", err)
+		} else {
+			fmt.Printf("Mined block %v %x\n", block.Header.Height, block.Header.Hash)
+		}
+		db.Close()
+	}
+}
+
+func workState(bc *Blockchain) string {
+	if _, ok := params.Engine().(BFT); ok {
+		// Restarting consensus would drop votes of the height, so only a
+		// new tip restarts it.
+		return fmt.Sprintf("%x", bc.LastHash())
+	}
+	return fmt.Sprintf("%x %v", bc.LastHash(), bc.Mempool.sorted())
+}
+
+func watchWork(ctx context.Context, restart context.CancelFunc, state string) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			db := GetDatabase()
+			changed := workState(db.Blockchain()) != state
+			db.Close()
+			if changed {
+				fmt.Println("Tip or mempool changed, restarting")
+				restart()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+)
+
+type Multisig struct {
+	M       int
+	PubKeys [][]byte
+}
+
+func NewMultisig(m int, pubKeys [][]byte) (*Multisig, error) {
+	if m < 1 || m > len(pubKeys) || len(pubKeys) > 16 {
+		return nil, errors.New("invalid number of signatures or public keys")
+	}
+	for _, pubKey := range pubKeys {
+		if len(pubKey) != 64 {
+			return nil, errors.New("invalid public key")
+		}
+	}
+	ms := &Multisig{m, pubKeys}
+	if len(ms.RedeemScript()) > maxElementSize {
+		return nil, errors.New("redeem script is too large")
+	}
+	return ms, nil
+}
+
+func (ms *Multisig) RedeemScript() Script {
+	return MultisigScript(ms.M, ms.PubKeys)
+}
+
+func (ms *Multisig) ScriptHash() []byte {
+	return Hash160(ms.RedeemScript())
+}
+
+func (ms *Multisig) LockScript() Script {
+	return PayToScriptHash(ms.ScriptHash())
+}
+
+func (ms *Multisig) Address() string {
+	return Address(scriptVersion, ms.ScriptHash())
+}
+
+type Multisigs map[string]*Multisig
+
+func (mss *Multisigs) Add(ms *Multisig) {
+	(*mss)[ms.Address()] = ms
+}
+
+func (mss *Multisigs) Multisig(address string) *Multisig {
+	return (*mss)[address]
+}
+
+func (mss *Multisigs) Serialize() []byte {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(mss)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func MultisigsDeserialize(data []byte) *Multisigs {
+	mss := &Multisigs{}
+	buf := bytes.NewBuffer(data)
+	dec := gob.NewDecoder(buf)
+	err := dec.Decode(mss)
+	if err != nil {
+		panic(err)
+	}
+	return mss
+}
@@ -0,0 +1,138 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const paramspath = "data/params.json"
+
+type Params struct {
+	CoinbaseMaturity int
+	InitialSubsidy   Amount
+	HalvingInterval  int
+	TailEmission     Amount
+	MaxBlockSize     int
+	// PoW names the proof of work hash function, see PoWs.
+	PoW string
+	// Consensus names the consensus engine, see Engines.
+	Consensus string
+	// Signers are addresses of initial signers of proof of authority, of
+	// validators of proof of stake until any stake is locked, and of BFT
+	// validators.
+	Signers []string
+	// BlockPeriod is the minimum number of seconds between signed blocks.
+	BlockPeriod int
+	// Seeds are URLs of peers which address manager starts from.
+	Seeds []string
+	// BanTime is the number of seconds a misbehaving peer is banned for.
+	BanTime int
+	// MempoolMaxSize is the total size of mempool transactions in bytes.
+	MempoolMaxSize      int
+	MempoolExpiry       int
+	MempoolMaxAncestors int
+	// MinRelayFee is the minimum fee per 1000 bytes accepted into mempool.
+	MinRelayFee Amount
+	// IncrementalRelayFee is the fee per 1000 bytes a replacement pays
+	// on top of fees of transactions it replaces.
+	IncrementalRelayFee Amount
+}
+
+var params = Params{
+	CoinbaseMaturity: 100,
+	InitialSubsidy:   10 * Coin,
+	HalvingInterval:  210000,
+	TailEmission:     0,
+	MaxBlockSize:     1000000,
+	PoW:              DefaultPoW,
+	Consensus:        DefaultEngine,
+	BlockPeriod:      5,
+	BanTime:          24 * 60 * 60,
+
+	MempoolMaxSize:      5000000,
+	MempoolExpiry:       14 * 24 * 60 * 60,
+	MempoolMaxAncestors: 25,
+	MinRelayFee:         0,
+	IncrementalRelayFee: 1000,
+}
+
+func (p *Params) Subsidy(height int) Amount {
+	subsidy := Amount(0)
+	if halvings := height / p.HalvingInterval; halvings < 63 {
+		subsidy = p.InitialSubsidy >> halvings
+	}
+	if subsidy < p.TailEmission {
+		subsidy = p.TailEmission
+	}
+	return subsidy
+}
+
+func (p *Params) NextHalving(height int) int {
+	return (height/p.HalvingInterval + 1) * p.HalvingInterval
+}
+
+// SupplyCap returns -1 when tail emission makes the supply unbounded.
+func (p *Params) SupplyCap() Amount {
+	if p.TailEmission > 0 {
+		return -1
+	}
+	supply := Amount(0)
+	for halvings := 0; halvings < 63 && p.InitialSubsidy>>halvings > 0; halvings++ {
+		supply += (p.InitialSubsidy >> halvings) * Amount(p.HalvingInterval)
+	}
+	return supply
+}
+
+func LoadParams(path string) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(data, &params)
+	if err != nil {
+		panic(err)
+	}
+	if params.HalvingInterval <= 0 {
+		panic("halving interval must be positive")
+	}
+	if params.InitialSubsidy < 0 || params.TailEmission < 0 {
+		panic("subsidy must not be negative")
+	}
+	if _, err := GetPoW(params.PoW); err != nil {
+		panic(err)
+	}
+	if _, err := GetEngine(params.Consensus); err != nil {
+		panic(err)
+	}
+	for _, signer := range params.Signers {
+		if lock, err := AddressScript(signer); err != nil || lock.PubKeyHash() == nil {
+			panic(fmt.Sprintf("invalid signer address %v", signer))
+		}
+	}
+	for _, seed := range params.Seeds {
+		if _, err := NormalizePeer(seed); err != nil {
+			panic(fmt.Sprintf("invalid seed %v: %v", seed, err))
+		}
+	}
+}
+
+func (p *Params) Engine() Engine {
+	engine, err := GetEngine(p.Consensus)
+	if err != nil {
+		panic(err)
+	}
+	return engine
+}
+
+func (p *Params) ProofOfWork() PoW {
+	pow, err := GetPoW(p.PoW)
+	if err != nil {
+		panic(err)
+	}
+	return pow
+}
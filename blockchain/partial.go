@@ -0,0 +1,184 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const partialTxVersion = 1
+
+type PartialTx struct {
+	Tx       *Tx
+	PrevOuts []*TxOut
+	Redeem   []Script
+	Sigs     []map[string][]byte
+}
+
+func NewPartialTx(tx *Tx, prevOuts []*TxOut, redeem []Script) *PartialTx {
+	sigs := make([]map[string][]byte, len(tx.TxIn))
+	for idx := range sigs {
+		sigs[idx] = make(map[string][]byte)
+	}
+	return &PartialTx{tx, prevOuts, redeem, sigs}
+}
+
+func (p *PartialTx) ID() string {
+	return fmt.Sprintf("%x", p.Tx.ID())
+}
+
+func (p *PartialTx) required(idx int) (int, [][]byte) {
+	lock := p.PrevOuts[idx].Script
+	if lock.PubKeyHash() != nil {
+		return 1, nil
+	}
+	if hash := lock.ScriptHash(); hash != nil && bytes.Equal(Hash160(p.Redeem[idx]), hash) {
+		if m, pubKeys, ok := p.Redeem[idx].Multisig(); ok {
+			return m, pubKeys
+		}
+	}
+	return 0, nil
+}
+
+func (p *PartialTx) CanSign(idx int, pubKey []byte) bool {
+	if hash := p.PrevOuts[idx].Script.PubKeyHash(); hash != nil {
+		return bytes.Equal(Hash160(pubKey), hash)
+	}
+	_, pubKeys := p.required(idx)
+	for _, key := range pubKeys {
+		if bytes.Equal(key, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PartialTx) Sign(w *Wallet, flag SigHashType) (int, error) {
+	signed := 0
+	pubKey := w.PubKey()
+	for idx := range p.Tx.TxIn {
+		if !p.CanSign(idx, pubKey) {
+			continue
+		}
+		sig, err := p.Tx.Signature(idx, w, flag)
+		if err != nil {
+			return 0, err
+		}
+		p.Sigs[idx][hex.EncodeToString(pubKey)] = sig
+		signed++
+	}
+	return signed, nil
+}
+
+func (p *PartialTx) Complete() bool {
+	for idx := range p.Tx.TxIn {
+		m, _ := p.required(idx)
+		if m == 0 || len(p.Sigs[idx]) < m {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *PartialTx) Finalize() (*Tx, error) {
+	if !p.Complete() {
+		return nil, errors.New("not enough signatures")
+	}
+	for idx, in := range p.Tx.TxIn {
+		m, pubKeys := p.required(idx)
+		script := Script{}
+		if pubKeys == nil {
+			for pubKey, sig := range p.Sigs[idx] {
+				key, _ := hex.DecodeString(pubKey)
+				script = script.AddData(sig).AddData(key)
+			}
+		} else {
+			n := 0
+			for _, pubKey := range pubKeys {
+				sig, ok := p.Sigs[idx][hex.EncodeToString(pubKey)]
+				if ok && n < m {
+					script = script.AddData(sig)
+					n++
+				}
+			}
+			script = script.AddData(p.Redeem[idx])
+		}
+		in.Script = script
+		if !p.Tx.VerifyInput(idx, p.PrevOuts[idx]) {
+			return nil, fmt.Errorf("input %d does not verify", idx)
+		}
+	}
+	return p.Tx, nil
+}
+
+func (p *PartialTx) Combine(other *PartialTx) error {
+	if p.ID() != other.ID() || len(p.Sigs) != len(other.Sigs) {
+		return errors.New("transactions do not match")
+	}
+	for idx, sigs := range other.Sigs {
+		for pubKey, sig := range sigs {
+			p.Sigs[idx][pubKey] = sig
+		}
+	}
+	return nil
+}
+
+type partialTxFile struct {
+	Version int
+	*PartialTx
+}
+
+func (p *PartialTx) Encode() []byte {
+	data, err := json.MarshalIndent(partialTxFile{partialTxVersion, p}, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func DecodePartialTx(data []byte) (*PartialTx, error) {
+	file := partialTxFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Version != partialTxVersion {
+		return nil, errors.New("unsupported partial transaction version")
+	}
+	p := file.PartialTx
+	if p == nil || p.Tx == nil || len(p.PrevOuts) != len(p.Tx.TxIn) ||
+		len(p.Redeem) != len(p.Tx.TxIn) || len(p.Sigs) != len(p.Tx.TxIn) {
+		return nil, errors.New("malformed partial transaction")
+	}
+	for idx := range p.Sigs {
+		if p.Sigs[idx] == nil {
+			p.Sigs[idx] = make(map[string][]byte)
+		}
+	}
+	return p, nil
+}
+
+type PartialTxs map[string]*PartialTx
+
+func (ps *PartialTxs) Serialize() []byte {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(ps)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func PartialTxsDeserialize(data []byte) *PartialTxs {
+	ps := &PartialTxs{}
+	buf := bytes.NewBuffer(data)
+	dec := gob.NewDecoder(buf)
+	err := dec.Decode(ps)
+	if err != nil {
+		panic(err)
+	}
+	return ps
+}
@@ -0,0 +1,441 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// banThreshold is the misbehaviour score at which a peer is banned.
+	banThreshold = 100
+	// maxAddrs bounds addresses in a single addr message.
+	maxAddrs = 1000
+	// maxPeers bounds entries of address manager.
+	maxPeers = 2000
+	// spamLimit is the number of requests a host may make per minute.
+	spamLimit = 600
+
+	ScoreInvalidBlock = 100
+	// ScoreRejectedBlock scores blocks rejected by submitblock, which come
+	// from miners rather than from peers relaying them.
+	ScoreRejectedBlock = 10
+	ScoreMalformed     = 20
+	ScoreSpam          = 10
+
+	DefaultOutbound = 8
+	// peerInterval is how often serve checks its outbound peers.
+	peerInterval = time.Minute
+)
+
+// PeerInfo is what address manager knows about a peer. Outbound peers are
+// identified by URL of their HTTP API, inbound ones by host. Misbehaviour
+// scores and bans are kept by host, so a banned host is neither served nor
+// connected to under any of its URLs.
+type PeerInfo struct {
+	Addr        string
+	Source      string
+	LastSeen    int
+	LastAttempt int
+	Failures    int
+	Score       int
+	BannedUntil int
+	BanReason   string
+}
+
+// AddrMan is the address manager, which holds known peers by address.
+type AddrMan map[string]*PeerInfo
+
+// NormalizePeer returns URL of a peer API without trailing slash.
+func NormalizePeer(addr string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(addr, "/"))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("peer address must be http or https URL")
+	}
+	return u.String(), nil
+}
+
+// PeerHost returns host of a peer URL, or addr itself when it is a host.
+func PeerHost(addr string) string {
+	if u, err := url.Parse(addr); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return addr
+}
+
+func (am *AddrMan) Sorted() []string {
+	addrs := make([]string, 0, len(*am))
+	for addr := range *am {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func (am *AddrMan) peer(addr, source string) *PeerInfo {
+	p := (*am)[addr]
+	if p == nil {
+		if len(*am) >= maxPeers {
+			am.evict(int(time.Now().Unix()))
+		}
+		p = &PeerInfo{Addr: addr, Source: source}
+		(*am)[addr] = p
+	}
+	return p
+}
+
+// evict makes room for a new entry by removing the worst one which is neither
+// a banned host nor connected, that is seen within the last two peerIntervals:
+// the one with the highest score of its host, then the most failures, then
+// seen the longest ago. It reports whether an entry was removed.
+func (am *AddrMan) evict(now int) bool {
+	connected := now - 2*int(peerInterval/time.Second)
+	worse := func(a, b string) bool {
+		pa, pb := (*am)[a], (*am)[b]
+		sa, sb := 0, 0
+		if h := am.Host(a); h != nil {
+			sa = h.Score
+		}
+		if h := am.Host(b); h != nil {
+			sb = h.Score
+		}
+		if sa != sb {
+			return sa > sb
+		}
+		if pa.Failures != pb.Failures {
+			return pa.Failures > pb.Failures
+		}
+		return pa.LastSeen < pb.LastSeen
+	}
+	worst := ""
+	for _, addr := range am.Sorted() {
+		p := (*am)[addr]
+		if p.BannedUntil > now || p.LastSeen > connected {
+			continue
+		}
+		if worst == "" || worse(addr, worst) {
+			worst = addr
+		}
+	}
+	if worst == "" {
+		return false
+	}
+	delete(*am, worst)
+	return true
+}
+
+// Add learns an outbound peer and reports whether it was not known yet. When
+// address manager is full and no entry can be evicted, the peer is dropped.
+func (am *AddrMan) Add(addr, source string) bool {
+	addr, err := NormalizePeer(addr)
+	if err != nil || (*am)[addr] != nil || len(*am) >= maxPeers && !am.evict(int(time.Now().Unix())) {
+		return false
+	}
+	am.peer(addr, source)
+	return true
+}
+
+// Host returns what address manager knows about host of a peer.
+func (am *AddrMan) Host(addr string) *PeerInfo {
+	return (*am)[PeerHost(addr)]
+}
+
+func (am *AddrMan) Banned(addr string, now int) bool {
+	p := am.Host(addr)
+	return p != nil && p.BannedUntil > now
+}
+
+func (am *AddrMan) Ban(addr, reason string, until int) {
+	p := am.peer(PeerHost(addr), "manual")
+	p.BannedUntil, p.BanReason, p.Score = until, reason, 0
+}
+
+// Bans returns ban expiry times of banned hosts.
+func (am *AddrMan) Bans(now int) map[string]int {
+	bans := make(map[string]int)
+	for addr, p := range *am {
+		if p.BannedUntil > now {
+			bans[addr] = p.BannedUntil
+		}
+	}
+	return bans
+}
+
+func (am *AddrMan) Unban(addr string) bool {
+	p := am.Host(addr)
+	if p == nil || p.BannedUntil == 0 {
+		return false
+	}
+	p.BannedUntil, p.BanReason, p.Score = 0, "", 0
+	return true
+}
+
+// Misbehave adds score to host of a peer and bans it for BanTime of chain
+// params once score reaches banThreshold. It reports whether the peer got
+// banned.
+func (am *AddrMan) Misbehave(addr, source string, score int, reason string) bool {
+	p := am.peer(PeerHost(addr), source)
+	p.Score += score
+	if p.Score < banThreshold {
+		return false
+	}
+	am.Ban(addr, reason, int(time.Now().Unix())+params.BanTime)
+	return true
+}
+
+func (am *AddrMan) Good(addr string) {
+	p := am.peer(addr, "manual")
+	p.LastSeen, p.LastAttempt, p.Failures = int(time.Now().Unix()), int(time.Now().Unix()), 0
+}
+
+func (am *AddrMan) Failed(addr string) {
+	p := am.peer(addr, "manual")
+	p.LastAttempt = int(time.Now().Unix())
+	p.Failures++
+}
+
+// Candidates returns outbound peers which are not banned, the most reliable
+// first: fewer failures, then seen more recently.
+func (am *AddrMan) Candidates() []string {
+	now := int(time.Now().Unix())
+	var addrs []string
+	for _, addr := range am.Sorted() {
+		if PeerHost(addr) != addr && !am.Banned(addr, now) {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		a, b := (*am)[addrs[i]], (*am)[addrs[j]]
+		if a.Failures != b.Failures {
+			return a.Failures < b.Failures
+		}
+		return a.LastSeen > b.LastSeen
+	})
+	return addrs
+}
+
+func (am *AddrMan) Serialize() []byte {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(am)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func AddrManDeserialize(data []byte) *AddrMan {
+	am := &AddrMan{}
+	buf := bytes.NewBuffer(data)
+	dec := gob.NewDecoder(buf)
+	err := dec.Decode(am)
+	if err != nil {
+		panic(err)
+	}
+	return am
+}
+
+// AddrMessage announces peer addresses.
+type AddrMessage struct {
+	Addrs []string `json:"addrs"`
+}
+
+// ConnectPeers selects up to target reachable outbound peers, trying peers
+// of previous selection first. Address manager, which is accessed through
+// withPeers, is seeded from Seeds of chain params, and learns addresses from
+// addr messages of every peer it connects to, which are tried in turn.
+func ConnectPeers(ctx context.Context, withPeers func(fn func(am *AddrMan) bool), previous []string, target int) []string {
+	withPeers(func(am *AddrMan) bool {
+		added := false
+		for _, seed := range params.Seeds {
+			added = am.Add(seed, "seed") || added
+		}
+		return added
+	})
+	tried := make(map[string]bool)
+	var connected []string
+	for len(connected) < target && ctx.Err() == nil {
+		addr := ""
+		withPeers(func(am *AddrMan) bool {
+			now := int(time.Now().Unix())
+			for _, candidate := range slices.Concat(previous, am.Candidates()) {
+				if !tried[candidate] && !am.Banned(candidate, now) {
+					addr = candidate
+					break
+				}
+			}
+			return false
+		})
+		if addr == "" {
+			break
+		}
+		tried[addr] = true
+		var msg AddrMessage
+		err := syncRequest(ctx, addr+"/getaddr", &msg)
+		withPeers(func(am *AddrMan) bool {
+			if err != nil {
+				var syntaxErr *json.SyntaxError
+				if errors.As(err, &syntaxErr) {
+					am.Misbehave(addr, "addr", ScoreMalformed, "malformed addr message")
+				}
+				am.Failed(addr)
+				return true
+			}
+			am.Good(addr)
+			connected = append(connected, addr)
+			if len(msg.Addrs) > maxAddrs {
+				am.Misbehave(addr, "addr", ScoreSpam, "too many addresses")
+				msg.Addrs = msg.Addrs[:maxAddrs]
+			}
+			for _, a := range msg.Addrs {
+				am.Add(a, "addr")
+			}
+			return true
+		})
+	}
+	return connected
+}
+
+// MaintainPeers keeps up to target outbound peers connected until ctx is
+// done. Every interval it probes connected peers, replaces unreachable and
+// banned ones, and reloads bans, which may be changed by other commands.
+func (s *Server) MaintainPeers(ctx context.Context, target int, interval time.Duration) {
+	for {
+		s.mu.Lock()
+		previous := s.outbound
+		s.mu.Unlock()
+		connected := ConnectPeers(ctx, s.withPeers, previous, target)
+		s.mu.Lock()
+		if !slices.Equal(previous, connected) {
+			fmt.Printf("Connected to %v of %v outbound peers\n", len(connected), target)
+		}
+		s.outbound = connected
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// withPeers opens database for address manager, and saves it when fn
+// reports a change. Bans of address manager are cached for admit.
+func (s *Server) withPeers(fn func(am *AddrMan) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db := GetDatabase()
+	defer db.Close()
+	am := db.AddrMan()
+	if am == nil {
+		am = new(AddrMan)
+		*am = make(AddrMan)
+	}
+	if fn(am) {
+		db.SetAddrMan(am)
+	}
+	s.bans = am.Bans(int(time.Now().Unix()))
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// misbehave scores inbound peer of request. Loopback hosts, which are local
+// miners and wallets, are not scored.
+func (s *Server) misbehave(r *http.Request, score int, reason string) {
+	host := remoteHost(r)
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return
+	}
+	s.withPeers(func(am *AddrMan) bool {
+		if am.Misbehave(host, "inbound", score, reason) {
+			fmt.Printf("Banned %v: %v\n", host, reason)
+		}
+		return true
+	})
+}
+
+// admit rejects requests of banned hosts, and scores hosts which make more
+// than spamLimit requests in a minute. Requests of pool miners, which poll
+// for work, are not counted. Bans are checked against the cache of withPeers,
+// so database is opened only when it is empty.
+func (s *Server) admit(r *http.Request) bool {
+	host := remoteHost(r)
+	s.mu.Lock()
+	cached := s.bans != nil
+	s.mu.Unlock()
+	if !cached {
+		s.withPeers(func(am *AddrMan) bool { return false })
+	}
+	now := time.Now().Unix()
+	s.mu.Lock()
+	if s.bans[host] > int(now) {
+		s.mu.Unlock()
+		return false
+	}
+	if s.minute != int(now/60) {
+		s.minute, s.requests = int(now/60), make(map[string]int)
+	}
+	if !strings.HasPrefix(r.URL.Path, "/pool/") {
+		s.requests[host]++
+	}
+	spam := s.requests[host] > spamLimit
+	s.mu.Unlock()
+	if spam {
+		s.misbehave(r, ScoreSpam, "too many requests")
+	}
+	return true
+}
+
+func (s *Server) getAddr(w http.ResponseWriter, r *http.Request) {
+	msg := &AddrMessage{Addrs: []string{}}
+	s.withPeers(func(am *AddrMan) bool {
+		candidates := am.Candidates()
+		msg.Addrs = append(msg.Addrs, candidates[:min(len(candidates), maxAddrs)]...)
+		return false
+	})
+	writeJSON(w, http.StatusOK, msg)
+}
+
+// addr learns addresses announced by a peer.
+func (s *Server) addr(w http.ResponseWriter, r *http.Request) {
+	var msg AddrMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		s.misbehave(r, ScoreMalformed, "malformed addr message")
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(msg.Addrs) > maxAddrs {
+		s.misbehave(r, ScoreSpam, "too many addresses")
+		writeError(w, http.StatusBadRequest, errors.New("too many addresses"))
+		return
+	}
+	added := 0
+	s.withPeers(func(am *AddrMan) bool {
+		for _, addr := range msg.Addrs {
+			if am.Add(addr, "addr") {
+				added++
+			}
+		}
+		return added > 0
+	})
+	writeJSON(w, http.StatusOK, map[string]int{"added": added})
+}
@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAddrManBans(t *testing.T) {
+	am := make(AddrMan)
+	now := int(time.Now().Unix())
+	am.Add("http://10.0.0.1:8080/", "manual")
+	am.Add("http://10.0.0.2:8080", "manual")
+	for range banThreshold / ScoreMalformed {
+		am.Misbehave("http://10.0.0.1:8080", "sync", ScoreMalformed, "malformed header")
+	}
+	if !am.Banned("10.0.0.1", now) || !am.Banned("http://10.0.0.1:9090", now) {
+		t.Error("host of misbehaving peer URL is not banned")
+	}
+	if got := am.Candidates(); !slices.Equal(got, []string{"http://10.0.0.2:8080"}) {
+		t.Errorf("candidates are %v", got)
+	}
+	am.Misbehave("10.0.0.2", "inbound", banThreshold, "invalid block")
+	if !am.Banned("http://10.0.0.2:8080", now) || len(am.Candidates()) != 0 {
+		t.Error("peer URL of banned inbound host is not banned")
+	}
+	if bans := am.Bans(now); len(bans) != 2 || bans["10.0.0.1"] == 0 || bans["10.0.0.2"] == 0 {
+		t.Errorf("bans are %v", bans)
+	}
+	if !am.Unban("http://10.0.0.1:8080") || am.Banned("10.0.0.1", now) {
+		t.Error("peer is not unbanned by URL")
+	}
+}
+
+func TestAddrManCapacity(t *testing.T) {
+	am := make(AddrMan)
+	for i := range maxPeers - 3 {
+		am.Add(fmt.Sprintf("http://10.1.%v.%v:8080", i/256, i%256), "addr")
+		am.Failed(fmt.Sprintf("http://10.1.%v.%v:8080", i/256, i%256))
+	}
+	am.Add("http://10.0.0.1:8080", "manual")
+	am.Good("http://10.0.0.1:8080")
+	am["http://10.0.0.1:8080"].Failures = 10
+	am.Add("http://10.0.0.2:8080", "addr")
+	am["http://10.0.0.2:8080"].Failures = 5
+	am.Ban("10.0.0.3", "manual", int(time.Now().Unix())+60)
+	if len(am) != maxPeers {
+		t.Fatalf("address manager holds %v entries, want %v", len(am), maxPeers)
+	}
+
+	if !am.Add("http://10.0.0.4:8080", "addr") || len(am) != maxPeers {
+		t.Fatalf("new peer is not added in place of an evicted one, %v entries", len(am))
+	}
+	if am["http://10.0.0.2:8080"] != nil {
+		t.Error("peer with the most failures is not evicted")
+	}
+	if am["http://10.0.0.1:8080"] == nil || am["10.0.0.3"] == nil {
+		t.Error("connected peer or banned host is evicted")
+	}
+
+	for addr, p := range am {
+		if addr != "http://10.0.0.1:8080" {
+			p.BannedUntil = int(time.Now().Unix()) + 60
+		}
+	}
+	if am.Add("http://10.0.0.5:8080", "addr") || len(am) != maxPeers {
+		t.Error("peer is added to address manager which has nothing to evict")
+	}
+}
+
+func TestConnectPeers(t *testing.T) {
+	testChain(t, params)
+	params.Seeds = nil
+	am := make(AddrMan)
+	withPeers := func(fn func(am *AddrMan) bool) { fn(&am) }
+	var urls []string
+	for i := range 3 {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, &AddrMessage{Addrs: urls[i+1 : min(i+2, len(urls))]})
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+	malformed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("addrs"))
+	}))
+	defer malformed.Close()
+	am.Add(malformed.URL, "manual")
+	am.Add(urls[0], "manual")
+	am.Failed(malformed.URL)
+
+	connected := ConnectPeers(context.Background(), withPeers, nil, 2)
+	if !slices.Equal(connected, urls[:2]) {
+		t.Fatalf("connected to %v, want %v", connected, urls[:2])
+	}
+	if am[urls[2]] == nil {
+		t.Error("address of peer is not learned")
+	}
+	connected = ConnectPeers(context.Background(), withPeers, []string{urls[1]}, 4)
+	if len(connected) != 3 || connected[0] != urls[1] {
+		t.Errorf("connected to %v, want previous peer %v first", connected, urls[1])
+	}
+	if am[malformed.URL].Failures != 2 || am.Host(malformed.URL).Score != ScoreMalformed {
+		t.Error("malformed addr message is not scored")
+	}
+}
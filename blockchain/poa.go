@@ -0,0 +1,294 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxFutureDrift bounds how far in the future timestamp of a signed block may be.
+const maxFutureDrift = 15
+
+// PoASeal is the seal of proof of authority block. Signer may vote to add or
+// remove a candidate signer, identified by public key hash.
+type PoASeal struct {
+	PubKey    []byte
+	Candidate []byte
+	Authorize bool
+	Signature []byte
+}
+
+func (s *PoASeal) Bytes() []byte {
+	e := &Encoder{}
+	e.WriteBytes(s.PubKey)
+	e.WriteBytes(s.Candidate)
+	authorize := uint32(0)
+	if s.Authorize {
+		authorize = 1
+	}
+	e.WriteUint32(authorize)
+	e.WriteBytes(s.Signature)
+	return e.Bytes()
+}
+
+func DecodePoASeal(data []byte) (*PoASeal, error) {
+	d := NewDecoder(data)
+	s := &PoASeal{}
+	s.PubKey = d.ReadBytes()
+	s.Candidate = d.ReadBytes()
+	authorize := d.ReadUint32()
+	s.Signature = d.ReadBytes()
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if d.Remaining() != 0 {
+		return nil, errors.New("trailing data after seal")
+	}
+	if authorize > 1 || len(s.Candidate) != 0 && len(s.Candidate) != 20 {
+		return nil, errors.New("invalid vote")
+	}
+	s.Authorize = authorize == 1
+	return s, nil
+}
+
+// SigHash commits to the block header and the vote.
+func (s *PoASeal) SigHash(h *BlockHeader) []byte {
+	unsigned := *s
+	unsigned.Signature = nil
+	hash := sha256.Sum256(append(h.Bytes(), unsigned.Bytes()...))
+	return hash[:]
+}
+
+func (s *PoASeal) Signer() string {
+	return Address(version, Hash160(s.PubKey))
+}
+
+// Snapshot is the set of signers and pending votes after a block. Signers are
+// sorted, and signer of block at height is Signers[height % len(Signers)].
+type Snapshot struct {
+	Signers []string
+	Votes   map[string]map[string]bool
+}
+
+func NewSnapshot(signers []string) *Snapshot {
+	s := &Snapshot{slices.Clone(signers), make(map[string]map[string]bool)}
+	sort.Strings(s.Signers)
+	s.Signers = slices.Compact(s.Signers)
+	return s
+}
+
+func (s *Snapshot) Authorized(addr string) bool {
+	_, ok := slices.BinarySearch(s.Signers, addr)
+	return ok
+}
+
+func (s *Snapshot) InTurn(height int) (string, error) {
+	if len(s.Signers) == 0 {
+		return "", errors.New("no authorized signers")
+	}
+	return s.Signers[height%len(s.Signers)], nil
+}
+
+// apply counts the vote of a block. Candidate is added or removed once more
+// than half of signers vote so, and votes cast by a removed signer are dropped.
+// Last signer can not be removed.
+func (s *Snapshot) apply(seal *PoASeal) *Snapshot {
+	if len(seal.Candidate) == 0 {
+		return s
+	}
+	candidate := Address(version, seal.Candidate)
+	if seal.Authorize == s.Authorized(candidate) {
+		return s
+	}
+	next := NewSnapshot(s.Signers)
+	for c, votes := range s.Votes {
+		next.Votes[c] = make(map[string]bool)
+		for signer, authorize := range votes {
+			next.Votes[c][signer] = authorize
+		}
+	}
+	if next.Votes[candidate] == nil {
+		next.Votes[candidate] = make(map[string]bool)
+	}
+	next.Votes[candidate][seal.Signer()] = seal.Authorize
+	tally := 0
+	for _, authorize := range next.Votes[candidate] {
+		if authorize == seal.Authorize {
+			tally++
+		}
+	}
+	if tally <= len(next.Signers)/2 {
+		return next
+	}
+	if !seal.Authorize && len(next.Signers) == 1 {
+		return next
+	}
+	delete(next.Votes, candidate)
+	if seal.Authorize {
+		next.Signers = append(next.Signers, candidate)
+		sort.Strings(next.Signers)
+		return next
+	}
+	for _, votes := range next.Votes {
+		delete(votes, candidate)
+	}
+	idx, _ := slices.BinarySearch(next.Signers, candidate)
+	next.Signers = slices.Delete(next.Signers, idx, idx+1)
+	return next
+}
+
+// PoA lets authorized signers take turns to sign blocks. Signer set starts
+// with Signers of chain params and changes by votes in block seals.
+type PoA struct {
+	mu        sync.Mutex
+	snapshots map[string]*Snapshot
+}
+
+func NewPoA() *PoA {
+	return &PoA{snapshots: make(map[string]*Snapshot)}
+}
+
+// Snapshot returns signers after block with hash, which is nil before the
+// first block.
+func (e *PoA) Snapshot(bc *Blockchain, hash []byte) (*Snapshot, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return replay(bc, e.snapshots, hash, NewSnapshot(params.Signers), func(s *Snapshot, b *Block) (*Snapshot, error) {
+		seal, err := DecodePoASeal(b.Seal)
+		if err != nil {
+			return nil, err
+		}
+		return s.apply(seal), nil
+	})
+}
+
+// Prepare checks that signer of m is in turn and votes for the first of
+// proposals which would change signers and is not voted for yet.
+func (e *PoA) Prepare(bc *Blockchain, b *Block, m *Miner) error {
+	if m.Signer == nil {
+		return errors.New("signer wallet is required")
+	}
+	snap, err := e.Snapshot(bc, b.Header.PrevHash)
+	if err != nil {
+		return err
+	}
+	signer := m.Signer.Address()
+	if !snap.Authorized(signer) {
+		return fmt.Errorf("%v is not an authorized signer", signer)
+	}
+	inTurn, err := snap.InTurn(b.Header.Height)
+	if err != nil {
+		return err
+	}
+	if inTurn != signer {
+		return fmt.Errorf("block %v is signed by %v", b.Header.Height, inTurn)
+	}
+	b.Header.Version = sealedBlockVersion
+	b.Header.Nonce = 0
+	if parent := bc.DB.Block(b.Header.PrevHash); parent != nil {
+		b.Header.Timestamp = max(b.Header.Timestamp, parent.Header.Timestamp+params.BlockPeriod)
+	}
+	seal := &PoASeal{PubKey: m.Signer.PubKey()}
+	if proposals := bc.DB.Proposals(); proposals != nil {
+		for _, addr := range proposals.Sorted() {
+			authorize := (*proposals)[addr]
+			if authorize == snap.Authorized(addr) || snap.Votes[addr] != nil && snap.Votes[addr][signer] == authorize {
+				continue
+			}
+			lock, _ := AddressScript(addr)
+			seal.Candidate, seal.Authorize = lock.PubKeyHash(), authorize
+			break
+		}
+	}
+	b.Seal = seal.Bytes()
+	return nil
+}
+
+// Seal waits until timestamp of the block and signs it.
+func (e *PoA) Seal(ctx context.Context, b *Block, m *Miner) (*Block, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Until(time.Unix(int64(b.Header.Timestamp), 0))):
+	}
+	seal, err := DecodePoASeal(b.Seal)
+	if err != nil {
+		return nil, err
+	}
+	seal.Signature = m.Signer.Sign(seal.SigHash(&b.Header))
+	b.Seal = seal.Bytes()
+	b.Header.Hash = b.Header.ComputeHash()
+	return b, nil
+}
+
+func (e *PoA) VerifyHeader(bc *Blockchain, b *Block) error {
+	if b.Header.Version != sealedBlockVersion {
+		return errors.New("unexpected block version")
+	}
+	seal, err := DecodePoASeal(b.Seal)
+	if err != nil {
+		return err
+	}
+	if !VerifySignature(seal.PubKey, seal.SigHash(&b.Header), seal.Signature) {
+		return errors.New("invalid block signature")
+	}
+	snap, err := e.Snapshot(bc, b.Header.PrevHash)
+	if err != nil {
+		return err
+	}
+	signer := seal.Signer()
+	if !snap.Authorized(signer) {
+		return fmt.Errorf("block is signed by unauthorized signer %v", signer)
+	}
+	if inTurn, _ := snap.InTurn(b.Header.Height); inTurn != signer {
+		return fmt.Errorf("block is signed out of turn by %v", signer)
+	}
+	if parent := bc.DB.Block(b.Header.PrevHash); parent != nil && b.Header.Timestamp < parent.Header.Timestamp+params.BlockPeriod {
+		return errors.New("block is signed too early")
+	}
+	if b.Header.Timestamp > int(time.Now().Unix())+maxFutureDrift {
+		return errors.New("block timestamp is too far in the future")
+	}
+	return nil
+}
+
+// Proposals are local votes of a signer, address maps to true to add a
+// signer and to false to remove it.
+type Proposals map[string]bool
+
+func (p *Proposals) Sorted() []string {
+	addrs := make([]string, 0, len(*p))
+	for addr := range *p {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func (p *Proposals) Serialize() []byte {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(p)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func ProposalsDeserialize(data []byte) *Proposals {
+	p := &Proposals{}
+	buf := bytes.NewBuffer(data)
+	dec := gob.NewDecoder(buf)
+	err := dec.Decode(p)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
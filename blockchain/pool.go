@@ -0,0 +1,318 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultShareDifficulty = 12
+	DefaultPPLNSWindow     = 1000
+	poolWorkInterval       = 10 * time.Second
+)
+
+type Share struct {
+	Address string
+	Hash    []byte
+	Height  int
+}
+
+// MiningPool hands out work with a share target below the block target and
+// keeps the last Window accepted shares. Coinbase of every job pays block
+// reward to miners in proportion to their shares in the window (PPLNS).
+type MiningPool struct {
+	*Server
+	ShareDifficulty int
+	Window          int
+	Shares          []*Share
+	Blocks          int
+	seen            map[string]bool
+	jobs            int
+}
+
+func NewMiningPool(shareDifficulty, window int) *MiningPool {
+	p := &MiningPool{
+		Server:          NewServer(),
+		ShareDifficulty: shareDifficulty,
+		Window:          window,
+		seen:            make(map[string]bool),
+	}
+	p.mux.HandleFunc("GET /pool/getwork", p.getWork)
+	p.mux.HandleFunc("POST /pool/submit", p.submitShare)
+	p.mux.HandleFunc("GET /pool/stats", p.stats)
+	return p
+}
+
+// PPLNS splits reward among addresses of shares in proportion to their count.
+// Payouts below dust threshold and rounding remainder go to the first payout.
+func PPLNS(shares []*Share, reward Amount) ([]*Payment, error) {
+	counts := make(map[string]int64)
+	var order []string
+	for _, s := range shares {
+		if counts[s.Address] == 0 {
+			order = append(order, s.Address)
+		}
+		counts[s.Address]++
+	}
+	var payouts []*Payment
+	paid := Amount(0)
+	for _, addr := range order {
+		amount := Amount(new(big.Int).Div(
+			new(big.Int).Mul(big.NewInt(int64(reward)), big.NewInt(counts[addr])),
+			big.NewInt(int64(len(shares))),
+		).Int64())
+		if amount < DustThreshold && len(payouts) > 0 {
+			continue
+		}
+		lock, err := AddressScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, &Payment{lock, amount})
+		paid += amount
+	}
+	if len(payouts) > 0 {
+		payouts[0].Amount += reward - paid
+	}
+	return payouts, nil
+}
+
+func (p *MiningPool) addShare(share *Share) {
+	p.Shares = append(p.Shares, share)
+	p.seen[string(share.Hash)] = true
+	if len(p.Shares) > p.Window {
+		delete(p.seen, string(p.Shares[0].Hash))
+		p.Shares = p.Shares[1:]
+	}
+}
+
+type PoolWork struct {
+	BlockTemplate
+	ShareTarget     string `json:"sharetarget"`
+	ShareDifficulty int    `json:"sharedifficulty"`
+}
+
+// getWork builds a job paying out shares of the window. Until the first share
+// is accepted, the whole reward goes to the requesting miner. Every job gets
+// a distinct extra nonce, so that miners do not search the same nonce space.
+func (p *MiningPool) getWork(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("address")
+	lock, err := AddressScript(addr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address: %v", err))
+		return
+	}
+	var work *PoolWork
+	err = p.withChain(func(bc *Blockchain, u *UTXOSet) error {
+		if _, ok := params.Engine().(PoWEngine); !ok {
+			return errNotPoW
+		}
+		var payoutErr error
+		block := bc.PayoutBlockTemplate(func(reward Amount) []*Payment {
+			if len(p.Shares) == 0 {
+				return []*Payment{{lock, reward}}
+			}
+			payouts, err := PPLNS(p.Shares, reward)
+			payoutErr = err
+			return payouts
+		})
+		if payoutErr != nil {
+			return payoutErr
+		}
+		p.jobs++
+		block.roll(p.jobs)
+		work = &PoolWork{*newBlockTemplate(bc, block), fmt.Sprintf("%x", Target(p.ShareDifficulty)), p.ShareDifficulty}
+		p.addTemplate(addr, work.MerkleRoot, block)
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, work)
+}
+
+type ShareRequest struct {
+	Address string `json:"address"`
+	Header  string `json:"header"`
+}
+
+type ShareResult struct {
+	Hash  string `json:"hash"`
+	Block bool   `json:"block"`
+}
+
+func (p *MiningPool) submitShare(w http.ResponseWriter, r *http.Request) {
+	var req ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := AddressScript(req.Address); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address: %v", err))
+		return
+	}
+	header, err := decodeHex(req.Header, DecodeBlockHeader)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p.mu.Lock()
+	job := p.templates[fmt.Sprintf("%x", header.MerkleRoot)]
+	p.mu.Unlock()
+	switch {
+	case job == nil:
+		err = errors.New("unknown job")
+	case !bytes.Equal(header.PrevHash, job.Header.PrevHash) || header.Height != job.Header.Height:
+		err = errors.New("header does not match job")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	result := &ShareResult{Hash: fmt.Sprintf("%x", header.Hash)}
+	err = p.withChain(func(bc *Blockchain, u *UTXOSet) error {
+		if !bytes.Equal(header.PrevHash, bc.LastHash()) {
+			return errors.New("stale share")
+		}
+		if p.seen[string(header.Hash)] {
+			return errors.New("duplicate share")
+		}
+		block := bytes.Compare(header.PoWHash(), Target(bc.Difficulty)) < 0
+		if !block && bytes.Compare(header.PoWHash(), Target(p.ShareDifficulty)) >= 0 {
+			return errors.New("share is above target")
+		}
+		p.addShare(&Share{req.Address, header.Hash, header.Height})
+		if !block {
+			return nil
+		}
+		if err := bc.SubmitBlock(&Block{*header, job.Txs, nil}, u); err != nil {
+			return fmt.Errorf("failed to submit block: %v", err)
+		}
+		result.Block = true
+		p.Blocks++
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+type PoolStats struct {
+	ShareDifficulty int               `json:"sharedifficulty"`
+	Window          int               `json:"window"`
+	Blocks          int               `json:"blocks"`
+	Shares          map[string]int    `json:"shares"`
+	Payouts         map[string]Amount `json:"payouts"`
+}
+
+// stats reports shares of each miner in the window and what a block found at
+// the next height would pay them.
+func (p *MiningPool) stats(w http.ResponseWriter, r *http.Request) {
+	stats := &PoolStats{p.ShareDifficulty, p.Window, 0, make(map[string]int), make(map[string]Amount)}
+	err := p.withChain(func(bc *Blockchain, u *UTXOSet) error {
+		stats.Blocks = p.Blocks
+		for _, s := range p.Shares {
+			stats.Shares[s.Address]++
+		}
+		if len(p.Shares) == 0 {
+			return nil
+		}
+		payouts, err := PPLNS(p.Shares, params.Subsidy(bc.Height()+1)+bc.Mempool.Fees())
+		if err != nil {
+			return err
+		}
+		for addr := range stats.Shares {
+			lock, _ := AddressScript(addr)
+			for _, payout := range payouts {
+				if bytes.Equal(payout.Script, lock) {
+					stats.Payouts[addr] = payout.Amount
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// MinePool mines shares for the pool at url, crediting them to address,
+// until ctx is cancelled. Work is refreshed after every share or poolWorkInterval.
+func MinePool(ctx context.Context, url, address string, m *Miner) {
+	for ctx.Err() == nil {
+		var work PoolWork
+		if err := poolRequest(url+"/pool/getwork?address="+address, nil, &work); err != nil {
+			fmt.Printf("Failed to Get Work: %v\n", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(poolWorkInterval):
+			}
+			continue
+		}
+		header, err := decodeHex(work.Header, DecodeBlockHeader)
+		if err == nil {
+			m.PoW, err = GetPoW(work.PoW)
+		}
+		if err != nil {
+			fmt.Printf("Failed to Decode Work: %v\n", err)
+			return
+		}
+		roundCtx, cancel := context.WithTimeout(ctx, poolWorkInterval)
+		block, err := m.Mine(roundCtx, &Block{Header: *header}, work.ShareDifficulty)
+		cancel()
+		if err != nil {
+			continue
+		}
+		var result ShareResult
+		req := &ShareRequest{address, fmt.Sprintf("%x", block.Header.Bytes())}
+		if err := poolRequest(url+"/pool/submit", req, &result); err != nil {
+			fmt.Printf("Share rejected: %v\n", err)
+		} else if result.Block {
+			fmt.Printf("Block found %v %v\n", work.Height, result.Hash)
+		} else {
+			fmt.Printf("Share accepted %v\n", result.Hash)
+		}
+	}
+}
+
+// poolRequest gets url, or posts body to it when body is not nil, and decodes
+// JSON response into v.
+func poolRequest(url string, body any, v any) error {
+	var resp *http.Response
+	var err error
+	if body == nil {
+		resp, err = http.Get(url)
+	} else {
+		data, _ := json.Marshal(body)
+		resp, err = http.Post(url, "application/json", bytes.NewReader(data))
+	}
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, v)
+}
+
+// decodeResponse decodes JSON body of response into v, or error of the API
+// when status is not OK.
+func decodeResponse(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct{ Error string }
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("unexpected status %v", resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,520 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSlotSearch bounds how many slots ahead a staker looks for its turn.
+	maxSlotSearch = 100
+	// maxEvidence bounds double signing evidence in a single seal.
+	maxEvidence = 8
+	// DefaultStakeLock is the number of blocks coins are staked for by default.
+	DefaultStakeLock = 1000
+)
+
+func slot(timestamp int) int {
+	return timestamp / max(params.BlockPeriod, 1)
+}
+
+// SignedHeader is a block header with its proof of stake seal.
+type SignedHeader struct {
+	Header BlockHeader
+	Seal   []byte
+}
+
+// DoubleSign is evidence that a staker signed two different blocks in the
+// same slot.
+type DoubleSign struct {
+	A, B SignedHeader
+}
+
+func (ds *DoubleSign) Bytes() []byte {
+	e := &Encoder{}
+	for _, sh := range []SignedHeader{ds.A, ds.B} {
+		e.Write(sh.Header.Bytes())
+		e.WriteBytes(sh.Seal)
+	}
+	return e.Bytes()
+}
+
+func (d *Decoder) ReadDoubleSign() *DoubleSign {
+	ds := &DoubleSign{}
+	for _, sh := range []*SignedHeader{&ds.A, &ds.B} {
+		sh.Header = d.ReadBlockHeader()
+		sh.Seal = d.ReadBytes()
+	}
+	return ds
+}
+
+func DecodeDoubleSign(data []byte) (*DoubleSign, error) {
+	d := NewDecoder(data)
+	ds := d.ReadDoubleSign()
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if d.Remaining() != 0 {
+		return nil, errors.New("trailing data after evidence")
+	}
+	return ds, nil
+}
+
+// Offender returns address of the staker who signed both headers.
+func (ds *DoubleSign) Offender() (string, error) {
+	a, err := DecodePoSSeal(ds.A.Seal)
+	if err != nil {
+		return "", err
+	}
+	b, err := DecodePoSSeal(ds.B.Seal)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !bytes.Equal(a.PubKey, b.PubKey):
+		return "", errors.New("headers are signed by different stakers")
+	case slot(ds.A.Header.Timestamp) != slot(ds.B.Header.Timestamp):
+		return "", errors.New("headers are signed in different slots")
+	case bytes.Equal(ds.A.Header.ComputeHash(), ds.B.Header.ComputeHash()):
+		return "", errors.New("headers are equal")
+	case !a.Verify(&ds.A.Header) || !b.Verify(&ds.B.Header):
+		return "", errors.New("invalid header signature")
+	}
+	return a.Signer(), nil
+}
+
+// PoSSeal is the seal of proof of stake block, which may carry evidence of
+// double signing by other stakers.
+type PoSSeal struct {
+	PubKey    []byte
+	Evidence  []*DoubleSign
+	Signature []byte
+}
+
+func (s *PoSSeal) Bytes() []byte {
+	e := &Encoder{}
+	e.WriteBytes(s.PubKey)
+	e.WriteVarInt(uint64(len(s.Evidence)))
+	for _, ds := range s.Evidence {
+		e.Write(ds.Bytes())
+	}
+	e.WriteBytes(s.Signature)
+	return e.Bytes()
+}
+
+func DecodePoSSeal(data []byte) (*PoSSeal, error) {
+	d := NewDecoder(data)
+	s := &PoSSeal{}
+	s.PubKey = d.ReadBytes()
+	count := d.ReadCount(2 * (88 + 1))
+	for i := 0; i < count && d.Err() == nil; i++ {
+		s.Evidence = append(s.Evidence, d.ReadDoubleSign())
+	}
+	s.Signature = d.ReadBytes()
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if d.Remaining() != 0 {
+		return nil, errors.New("trailing data after seal")
+	}
+	return s, nil
+}
+
+// SigHash commits to the block header and the evidence.
+func (s *PoSSeal) SigHash(h *BlockHeader) []byte {
+	unsigned := *s
+	unsigned.Signature = nil
+	hash := sha256.Sum256(append(h.Bytes(), unsigned.Bytes()...))
+	return hash[:]
+}
+
+func (s *PoSSeal) Verify(h *BlockHeader) bool {
+	return VerifySignature(s.PubKey, s.SigHash(h), s.Signature)
+}
+
+func (s *PoSSeal) Signer() string {
+	return Address(version, Hash160(s.PubKey))
+}
+
+// Stake is an output locked with TimeLockScript until block height Unlock.
+// It has weight in leader selection of blocks below that height.
+type Stake struct {
+	Address string
+	Value   Amount
+	Unlock  int
+}
+
+func TxStake(out *TxOut) (*Stake, bool) {
+	lockTime, pubKeyHash, ok := out.Script.TimeLock()
+	if !ok || lockTime >= lockTimeThreshold {
+		return nil, false
+	}
+	return &Stake{Address(version, pubKeyHash), out.Value, lockTime}, true
+}
+
+// StakeSnapshot holds stakes by outpoint and slashed stakers after a block.
+type StakeSnapshot struct {
+	Stakes  map[string]*Stake
+	Slashed map[string]bool
+}
+
+func NewStakeSnapshot() *StakeSnapshot {
+	return &StakeSnapshot{make(map[string]*Stake), make(map[string]bool)}
+}
+
+func (s *StakeSnapshot) apply(b *Block) (*StakeSnapshot, error) {
+	seal, err := DecodePoSSeal(b.Seal)
+	if err != nil {
+		return nil, err
+	}
+	next := &StakeSnapshot{maps.Clone(s.Stakes), maps.Clone(s.Slashed)}
+	changed := false
+	for _, ds := range seal.Evidence {
+		offender, err := ds.Offender()
+		if err != nil {
+			return nil, err
+		}
+		next.Slashed[offender], changed = true, true
+	}
+	for _, tx := range b.Txs {
+		for _, in := range tx.TxIn {
+			op := outpoint(in.TxOutHash, in.TxOutIndex)
+			if next.Stakes[op] != nil {
+				delete(next.Stakes, op)
+				changed = true
+			}
+		}
+		for idx, out := range tx.TxOut {
+			if stake, ok := TxStake(out); ok {
+				next.Stakes[outpoint(tx.ID(), idx)], changed = stake, true
+			}
+		}
+	}
+	if !changed {
+		return s, nil
+	}
+	return next, nil
+}
+
+// Weights returns stake of every staker which is not slashed, counting only
+// stakes still locked at height.
+func (s *StakeSnapshot) Weights(height int) map[string]Amount {
+	weights := make(map[string]Amount)
+	for _, stake := range s.Stakes {
+		if stake.Unlock > height && !s.Slashed[stake.Address] {
+			weights[stake.Address] += stake.Value
+		}
+	}
+	return weights
+}
+
+// leaderSeed returns the hash which seeds leaders of the block after parent:
+// the hash of its grandparent, which the leader of parent cannot vary.
+func leaderSeed(bc *Blockchain, parentHash []byte) []byte {
+	if parent := bc.DB.Block(parentHash); parent != nil {
+		return parent.Header.PrevHash
+	}
+	return nil
+}
+
+// Leader selects signer of block at height in slot, with probability
+// proportional to stake. Seed is derived from the leaderSeed hash and slot.
+// Until any stake is locked, every validator of chain params has equal weight.
+func (s *StakeSnapshot) Leader(seedHash []byte, height, slot int) (string, error) {
+	weights := s.Weights(height)
+	if len(weights) == 0 {
+		for _, signer := range params.Signers {
+			if !s.Slashed[signer] {
+				weights[signer] = 1
+			}
+		}
+	}
+	if len(weights) == 0 {
+		return "", errors.New("no stakers")
+	}
+	stakers := make([]string, 0, len(weights))
+	total := Amount(0)
+	for addr, weight := range weights {
+		stakers = append(stakers, addr)
+		total += weight
+	}
+	sort.Strings(stakers)
+	seed := make([]byte, 8)
+	binary.LittleEndian.PutUint64(seed, uint64(slot))
+	hash := sha256.Sum256(append(append([]byte(nil), seedHash...), seed...))
+	r := Amount(new(big.Int).Mod(new(big.Int).SetBytes(hash[:]), big.NewInt(int64(total))).Int64())
+	for _, addr := range stakers {
+		if r < weights[addr] {
+			return addr, nil
+		}
+		r -= weights[addr]
+	}
+	panic("unreachable")
+}
+
+// PoS selects a leader for every slot of BlockPeriod seconds, who signs the
+// block of the slot. Stakers who sign two blocks in a slot are slashed by
+// evidence included in a later block, and lose their weight for good.
+type PoS struct {
+	mu        sync.Mutex
+	snapshots map[string]*StakeSnapshot
+}
+
+func NewPoS() *PoS {
+	return &PoS{snapshots: make(map[string]*StakeSnapshot)}
+}
+
+// Snapshot returns stakes after block with hash, which is nil before the
+// first block.
+func (e *PoS) Snapshot(bc *Blockchain, hash []byte) (*StakeSnapshot, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return replay(bc, e.snapshots, hash, NewStakeSnapshot(), (*StakeSnapshot).apply)
+}
+
+// Prepare moves the block into the first slot led by signer of m, no earlier
+// than the block timestamp, and includes pending double signing evidence.
+func (e *PoS) Prepare(bc *Blockchain, b *Block, m *Miner) error {
+	if m.Signer == nil {
+		return errors.New("signer wallet is required")
+	}
+	snap, err := e.Snapshot(bc, b.Header.PrevHash)
+	if err != nil {
+		return err
+	}
+	first := slot(b.Header.Timestamp)
+	if parent := bc.DB.Block(b.Header.PrevHash); parent != nil {
+		first = max(first, slot(parent.Header.Timestamp)+1)
+	}
+	signer := m.Signer.Address()
+	if snap.Slashed[signer] {
+		return fmt.Errorf("%v is slashed", signer)
+	}
+	seedHash := leaderSeed(bc, b.Header.PrevHash)
+	s := first
+	for ; s < first+maxSlotSearch; s++ {
+		leader, err := snap.Leader(seedHash, b.Header.Height, s)
+		if err != nil {
+			return err
+		}
+		if leader == signer {
+			break
+		}
+	}
+	if s == first+maxSlotSearch {
+		return fmt.Errorf("%v does not lead any of next %v slots", signer, maxSlotSearch)
+	}
+	b.Header.Version = sealedBlockVersion
+	b.Header.Nonce = 0
+	b.Header.Timestamp = s * max(params.BlockPeriod, 1)
+	seal := &PoSSeal{PubKey: m.Signer.PubKey()}
+	if evidence := bc.DB.Evidence(); evidence != nil {
+		for _, offender := range evidence.Sorted() {
+			if !snap.Slashed[offender] && len(seal.Evidence) < maxEvidence {
+				ds, err := DecodeDoubleSign((*evidence)[offender])
+				if err != nil {
+					return err
+				}
+				seal.Evidence = append(seal.Evidence, ds)
+			}
+		}
+	}
+	b.Seal = seal.Bytes()
+	return nil
+}
+
+// Seal waits until the slot of the block and signs it.
+func (e *PoS) Seal(ctx context.Context, b *Block, m *Miner) (*Block, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Until(time.Unix(int64(b.Header.Timestamp), 0))):
+	}
+	seal, err := DecodePoSSeal(b.Seal)
+	if err != nil {
+		return nil, err
+	}
+	seal.Signature = m.Signer.Sign(seal.SigHash(&b.Header))
+	b.Seal = seal.Bytes()
+	b.Header.Hash = b.Header.ComputeHash()
+	return b, nil
+}
+
+func (e *PoS) VerifyHeader(bc *Blockchain, b *Block) error {
+	if b.Header.Version != sealedBlockVersion {
+		return errors.New("unexpected block version")
+	}
+	seal, err := DecodePoSSeal(b.Seal)
+	if err != nil {
+		return err
+	}
+	if !seal.Verify(&b.Header) {
+		return errors.New("invalid block signature")
+	}
+	snap, err := e.Snapshot(bc, b.Header.PrevHash)
+	if err != nil {
+		return err
+	}
+	s := slot(b.Header.Timestamp)
+	if parent := bc.DB.Block(b.Header.PrevHash); parent != nil && s <= slot(parent.Header.Timestamp) {
+		return errors.New("block is not in a later slot than its parent")
+	}
+	if b.Header.Timestamp > int(time.Now().Unix())+maxFutureDrift {
+		return errors.New("block timestamp is too far in the future")
+	}
+	leader, err := snap.Leader(leaderSeed(bc, b.Header.PrevHash), b.Header.Height, s)
+	if err != nil {
+		return err
+	}
+	if signer := seal.Signer(); signer != leader {
+		return fmt.Errorf("block is signed by %v, which does not lead slot %v", signer, s)
+	}
+	if len(seal.Evidence) > maxEvidence {
+		return errors.New("too much evidence")
+	}
+	offenders := make(map[string]bool)
+	for _, ds := range seal.Evidence {
+		offender, err := ds.Offender()
+		if err != nil {
+			return fmt.Errorf("invalid evidence: %v", err)
+		}
+		if snap.Slashed[offender] || offenders[offender] {
+			return fmt.Errorf("%v is already slashed", offender)
+		}
+		offenders[offender] = true
+	}
+	return nil
+}
+
+// Report stores evidence when block is signed in the same slot and by the
+// same staker as the block at its height in chain.
+func (e *PoS) Report(bc *Blockchain, b *Block) error {
+	if b.Header.Version != sealedBlockVersion {
+		return errors.New("unexpected block version")
+	}
+	key := bc.DB.Key
+	defer func() { bc.DB.Key = key }()
+	bc.DB.BlockchainTip()
+	block := bc.DB.NextBlock()
+	for block != nil && block.Header.Height > b.Header.Height {
+		block = bc.DB.NextBlock()
+	}
+	if block == nil || block.Header.Height != b.Header.Height {
+		return errors.New("no block at the same height")
+	}
+	ds := &DoubleSign{SignedHeader{block.Header, block.Seal}, SignedHeader{b.Header, b.Seal}}
+	offender, err := ds.Offender()
+	if err != nil {
+		return err
+	}
+	evidence := bc.DB.Evidence()
+	if evidence == nil {
+		evidence = new(Evidence)
+		*evidence = make(Evidence)
+	}
+	if _, ok := (*evidence)[offender]; !ok {
+		(*evidence)[offender] = ds.Bytes()
+		bc.DB.SetEvidence(evidence)
+	}
+	return nil
+}
+
+// StakeTx locks amount of coins of w as stake until block height unlock.
+func StakeTx(w *Wallet, amount Amount, unlock, height int, u *UTXOSet, cc *CoinControl) (*Tx, error) {
+	payment := &Payment{TimeLockScript(unlock, w.PubKeyHash()), amount}
+	tx, _, err := PaymentTx(w, []*Payment{payment}, params.MinRelayFee, height, u, cc)
+	return tx, err
+}
+
+// WithdrawStakeTx spends stakes of w unlocked at height back to its address.
+func WithdrawStakeTx(w *Wallet, height int, u *UTXOSet) (*Tx, error) {
+	tx := &Tx{LockTime: height}
+	total := Amount(0)
+	for txHash, outs := range *u {
+		for idx, utxo := range outs {
+			lockTime, pubKeyHash, ok := utxo.Script.TimeLock()
+			if !ok || lockTime > height || lockTime >= lockTimeThreshold || !bytes.Equal(pubKeyHash, w.PubKeyHash()) {
+				continue
+			}
+			hash, _ := hex.DecodeString(txHash)
+			tx.TxIn = append(tx.TxIn, &TxIn{hash, idx, nil, SequenceFinal})
+			total += utxo.Value
+		}
+	}
+	if len(tx.TxIn) == 0 {
+		return nil, errors.New("no unlocked stake")
+	}
+	sort.Slice(tx.TxIn, func(i, j int) bool {
+		return outpoint(tx.TxIn[i].TxOutHash, tx.TxIn[i].TxOutIndex) < outpoint(tx.TxIn[j].TxOutHash, tx.TxIn[j].TxOutIndex)
+	})
+	tx.TxOut = []*TxOut{{total, w.LockScript()}}
+	tx.Sign(w)
+	fee := params.MinRelayFee * Amount(len(tx.Bytes())) / 1000
+	if total-fee < DustThreshold {
+		return nil, errors.New("unlocked stake does not cover fee")
+	}
+	tx.TxOut[0].Value = total - fee
+	tx.Sign(w)
+	return tx, nil
+}
+
+func (bc *Blockchain) Stake(w *Wallet, amount Amount, unlock int, u *UTXOSet, cc *CoinControl) (*Tx, error) {
+	tx, err := StakeTx(w, amount, unlock, bc.Height()+1, bc.Mempool.View(u), cc)
+	if err != nil {
+		return nil, err
+	}
+	return tx, bc.Submit(tx, u)
+}
+
+func (bc *Blockchain) WithdrawStake(w *Wallet, u *UTXOSet) (*Tx, error) {
+	tx, err := WithdrawStakeTx(w, bc.Height()+1, bc.Mempool.View(u))
+	if err != nil {
+		return nil, err
+	}
+	return tx, bc.Submit(tx, u)
+}
+
+// Evidence holds double signing evidence not yet included in chain by
+// address of offender.
+type Evidence map[string][]byte
+
+func (ev *Evidence) Sorted() []string {
+	addrs := make([]string, 0, len(*ev))
+	for addr := range *ev {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func (ev *Evidence) Serialize() []byte {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(ev)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func EvidenceDeserialize(data []byte) *Evidence {
+	ev := &Evidence{}
+	buf := bytes.NewBuffer(data)
+	dec := gob.NewDecoder(buf)
+	err := dec.Decode(ev)
+	if err != nil {
+		panic(err)
+	}
+	return ev
+}
@@ -0,0 +1,193 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testChain sets chain params to p and opens an empty chain in a temporary
+// directory, restoring params when the test ends.
+func testChain(t *testing.T, p Params) *Blockchain {
+	saved := params
+	params = p
+	db := OpenDatabase(filepath.Join(t.TempDir(), "blockchain.db"))
+	t.Cleanup(func() {
+		db.Close()
+		params = saved
+	})
+	return db.Blockchain()
+}
+
+// testValidators returns wallets of n validators and params with them as
+// signers.
+func testValidators(n int, p Params) ([]*Wallet, Params) {
+	ws := make(Wallets)
+	wallets := make([]*Wallet, n)
+	p.Signers = nil
+	for i := range wallets {
+		wallets[i] = ws.NewWallet()
+		p.Signers = append(p.Signers, wallets[i].Address())
+	}
+	return wallets, p
+}
+
+// signPoS signs block as m in its slot, which is in the past.
+func signPoS(t *testing.T, engine *PoS, bc *Blockchain, block *Block, m *Miner) *Block {
+	if err := engine.Prepare(bc, block, m); err != nil {
+		t.Fatal(err)
+	}
+	block, err := engine.Seal(context.Background(), block, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestPoS(t *testing.T) {
+	const blocks = 12
+	p := params
+	p.Consensus, p.CoinbaseMaturity, p.MinRelayFee, p.BlockPeriod = "pos", 1, 0, 5
+	wallets, p := testValidators(4, p)
+	bc := testChain(t, p)
+	engine := params.Engine().(*PoS)
+	byWallet := make(map[string]*Wallet)
+	for _, w := range wallets {
+		byWallet[w.Address()] = w
+	}
+	u := make(UTXOSet)
+	next := int(time.Now().Unix())/params.BlockPeriod - 2*blocks
+	// byzantine is the leader of the first block, which also signs a
+	// conflicting block in its slot.
+	var byzantine string
+	for i := range blocks {
+		height := bc.Height() + 1
+		snap, err := engine.Snapshot(bc, bc.LastHash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range wallets {
+			total := Amount(0)
+			for _, out := range bc.Mempool.View(&u).Spendable(w.LockScript(), height) {
+				total += out.Value
+			}
+			if total == 0 || snap.Slashed[w.Address()] {
+				continue
+			}
+			if _, err := bc.Stake(w, total, height+DefaultStakeLock, &u, nil); err != nil {
+				t.Fatalf("block %v: %v failed to stake: %v", height, w.Address(), err)
+			}
+		}
+		leader, err := snap.Leader(leaderSeed(bc, bc.LastHash()), height, next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if weights := snap.Weights(height); len(weights) > 0 && weights[leader] == 0 {
+			t.Errorf("block %v: leader %v has no stake", height, leader)
+		}
+		if snap.Slashed[leader] {
+			t.Errorf("block %v: slashed %v leads", height, leader)
+		}
+
+		template := bc.BlockTemplate(byWallet[leader].LockScript())
+		template.Header.Timestamp = next * params.BlockPeriod
+		for _, w := range wallets {
+			if w.Address() == leader {
+				continue
+			}
+			forged := *template
+			forged.Header.Version = sealedBlockVersion
+			seal := &PoSSeal{PubKey: w.PubKey()}
+			seal.Signature = w.Sign(seal.SigHash(&forged.Header))
+			forged.Seal = seal.Bytes()
+			forged.Header.Hash = forged.Header.ComputeHash()
+			if err := engine.VerifyHeader(bc, &forged); err == nil {
+				t.Errorf("block %v: block signed by %v in slot led by %v is accepted", height, w.Address(), leader)
+			}
+		}
+
+		m := &Miner{Signer: byWallet[leader]}
+		conflict := *template
+		block := signPoS(t, engine, bc, template, m)
+		if slot(block.Header.Timestamp) != next {
+			t.Fatalf("block %v: leader of slot %v signs in slot %v", height, next, slot(block.Header.Timestamp))
+		}
+		if err := bc.SubmitBlock(block, &u); err != nil {
+			t.Fatalf("block %v: %v", height, err)
+		}
+		seal, _ := DecodePoSSeal(block.Seal)
+		if i == 1 {
+			if len(seal.Evidence) != 1 {
+				t.Fatalf("block %v includes %v pieces of evidence, want 1", height, len(seal.Evidence))
+			}
+			if offender, err := seal.Evidence[0].Offender(); err != nil || offender != byzantine {
+				t.Errorf("evidence of block %v is against %v, %v, want %v", height, offender, err, byzantine)
+			}
+		} else if len(seal.Evidence) != 0 {
+			t.Errorf("block %v includes evidence", height)
+		}
+		if i == 0 {
+			byzantine = leader
+			conflict.roll(1)
+			conflict.Header.Timestamp = block.Header.Timestamp
+			conflict.Seal = nil
+			if err := bc.SubmitBlock(signPoS(t, engine, bc, &conflict, m), &u); err == nil {
+				t.Fatal("conflicting block is accepted")
+			}
+			if ev := bc.DB.Evidence(); ev == nil || (*ev)[byzantine] == nil {
+				t.Fatal("double signing is not reported")
+			}
+		}
+		next = slot(block.Header.Timestamp) + 1
+	}
+
+	snap, err := engine.Snapshot(bc, bc.LastHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	weights := snap.Weights(bc.Height() + 1)
+	if len(weights) == 0 {
+		t.Error("no stake is locked")
+	}
+	for _, w := range wallets {
+		addr := w.Address()
+		if snap.Slashed[addr] != (addr == byzantine) {
+			t.Errorf("%v is slashed %v", addr, snap.Slashed[addr])
+		}
+		if addr == byzantine && weights[addr] != 0 {
+			t.Errorf("slashed %v has weight %v", addr, weights[addr])
+		}
+	}
+	template := bc.BlockTemplate(byWallet[byzantine].LockScript())
+	if err := engine.Prepare(bc, template, &Miner{Signer: byWallet[byzantine]}); err == nil {
+		t.Error("slashed staker prepares a block")
+	}
+	if !bc.Verify() {
+		t.Error("chain does not verify")
+	}
+}
+
+func TestLeaderSeed(t *testing.T) {
+	p := params
+	p.Consensus = "pow"
+	bc := testChain(t, p)
+	w := newTestWallet()
+	u := make(UTXOSet)
+	if _, err := bc.Mine(context.Background(), w, &u, NewMiner(1)); err != nil {
+		t.Fatal(err)
+	}
+	grandparent := bc.LastHash()
+	a, b := sealBlock(t, bc, w), sealBlock(t, bc, newTestWallet())
+	bc.DB.AddBlock(a)
+	bc.DB.AddBlock(b)
+	if bytes.Equal(a.Header.Hash, b.Header.Hash) {
+		t.Fatal("sibling blocks have equal hashes")
+	}
+	for _, parent := range []*Block{a, b} {
+		if seed := leaderSeed(bc, parent.Header.Hash); !bytes.Equal(seed, grandparent) {
+			t.Errorf("leader seed after %x is %x, want grandparent %x", parent.Header.Hash, seed, grandparent)
+		}
+	}
+}
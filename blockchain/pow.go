@@ -0,0 +1,71 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PoW hashes a serialized block header for proof of work. Block is valid
+// when its proof of work hash is below target of the chain difficulty.
+type PoW interface {
+	Hash(header []byte) []byte
+}
+
+var PoWs = map[string]PoW{
+	"sha256":   SHA256{},
+	"sha256d":  SHA256d{},
+	"scrypt":   Scrypt{N: 1024, R: 1, P: 1},
+	"argon2id": Argon2id{Time: 1, Memory: 4 * 1024, Threads: 1},
+}
+
+const DefaultPoW = "sha256"
+
+func GetPoW(name string) (PoW, error) {
+	pow, ok := PoWs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown proof of work %v", name)
+	}
+	return pow, nil
+}
+
+type SHA256 struct{}
+
+func (SHA256) Hash(header []byte) []byte {
+	hash := sha256.Sum256(header)
+	return hash[:]
+}
+
+type SHA256d struct{}
+
+func (SHA256d) Hash(header []byte) []byte {
+	hash := sha256.Sum256(header)
+	hash = sha256.Sum256(hash[:])
+	return hash[:]
+}
+
+// Scrypt uses header both as password and salt.
+type Scrypt struct {
+	N, R, P int
+}
+
+func (s Scrypt) Hash(header []byte) []byte {
+	hash, err := scrypt.Key(header, header, s.N, s.R, s.P, hashSize)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// Argon2id uses header both as password and salt. Memory is in KiB.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+func (a Argon2id) Hash(header []byte) []byte {
+	return argon2.IDKey(header, header, a.Time, a.Memory, a.Threads, hashSize)
+}
@@ -0,0 +1,668 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	OP_0                   = 0x00
+	OP_PUSHDATA1           = 0x4c
+	OP_PUSHDATA2           = 0x4d
+	OP_1NEGATE             = 0x4f
+	OP_1                   = 0x51
+	OP_16                  = 0x60
+	OP_NOP                 = 0x61
+	OP_IF                  = 0x63
+	OP_NOTIF               = 0x64
+	OP_ELSE                = 0x67
+	OP_ENDIF               = 0x68
+	OP_VERIFY              = 0x69
+	OP_RETURN              = 0x6a
+	OP_DROP                = 0x75
+	OP_DUP                 = 0x76
+	OP_SWAP                = 0x7c
+	OP_SIZE                = 0x82
+	OP_EQUAL               = 0x87
+	OP_EQUALVERIFY         = 0x88
+	OP_SHA256              = 0xa8
+	OP_HASH160             = 0xa9
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+)
+
+const (
+	maxScriptSize     = 10000
+	maxScriptOps      = 201
+	maxStackSize      = 1000
+	maxElementSize    = 520
+	maxMultisigKeys   = 20
+	maxNumSize        = 4
+	lockTimeThreshold = 500000000
+)
+
+var opNames = map[byte]string{
+	OP_0:                   "OP_0",
+	OP_PUSHDATA1:           "OP_PUSHDATA1",
+	OP_PUSHDATA2:           "OP_PUSHDATA2",
+	OP_1NEGATE:             "OP_1NEGATE",
+	OP_NOP:                 "OP_NOP",
+	OP_IF:                  "OP_IF",
+	OP_NOTIF:               "OP_NOTIF",
+	OP_ELSE:                "OP_ELSE",
+	OP_ENDIF:               "OP_ENDIF",
+	OP_VERIFY:              "OP_VERIFY",
+	OP_RETURN:              "OP_RETURN",
+	OP_DROP:                "OP_DROP",
+	OP_DUP:                 "OP_DUP",
+	OP_SWAP:                "OP_SWAP",
+	OP_SIZE:                "OP_SIZE",
+	OP_EQUAL:               "OP_EQUAL",
+	OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+	OP_SHA256:              "OP_SHA256",
+	OP_HASH160:             "OP_HASH160",
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+}
+
+type Script []byte
+
+type Instruction struct {
+	Op   byte
+	Data []byte
+}
+
+func (s Script) AddOp(op byte) Script {
+	return append(s, op)
+}
+
+func (s Script) AddData(data []byte) Script {
+	n := len(data)
+	switch {
+	case n == 0:
+		return append(s, OP_0)
+	case n == 1 && data[0] >= 1 && data[0] <= 16:
+		return append(s, OP_1+data[0]-1)
+	case n < OP_PUSHDATA1:
+		s = append(s, byte(n))
+	case n <= 0xff:
+		s = append(s, OP_PUSHDATA1, byte(n))
+	default:
+		s = append(s, OP_PUSHDATA2)
+		s = binary.LittleEndian.AppendUint16(s, uint16(n))
+	}
+	return append(s, data...)
+}
+
+func (s Script) AddInt(n int64) Script {
+	if n == -1 {
+		return append(s, OP_1NEGATE)
+	}
+	return s.AddData(EncodeScriptNum(n))
+}
+
+func (s Script) Parse() ([]Instruction, error) {
+	instrs := make([]Instruction, 0)
+	for i := 0; i < len(s); {
+		op := s[i]
+		i++
+		var n int
+		switch {
+		case op > OP_0 && op < OP_PUSHDATA1:
+			n = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(s) {
+				return nil, errors.New("truncated push")
+			}
+			n = int(s[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(s) {
+				return nil, errors.New("truncated push")
+			}
+			n = int(binary.LittleEndian.Uint16(s[i:]))
+			i += 2
+		default:
+			instrs = append(instrs, Instruction{op, nil})
+			continue
+		}
+		if i+n > len(s) {
+			return nil, errors.New("truncated push")
+		}
+		instrs = append(instrs, Instruction{op, s[i : i+n]})
+		i += n
+	}
+	return instrs, nil
+}
+
+func (s Script) PushOnly() bool {
+	instrs, err := s.Parse()
+	if err != nil {
+		return false
+	}
+	for _, instr := range instrs {
+		if instr.Op > OP_16 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Script) PubKeyHash() []byte {
+	if len(s) == 25 && s[0] == OP_DUP && s[1] == OP_HASH160 && s[2] == 20 &&
+		s[23] == OP_EQUALVERIFY && s[24] == OP_CHECKSIG {
+		return s[3:23]
+	}
+	return nil
+}
+
+// TimeLock returns lock time and public key hash of TimeLockScript.
+func (s Script) TimeLock() (int, []byte, bool) {
+	instrs, err := s.Parse()
+	if err != nil || len(instrs) != 8 || len(instrs[5].Data) != 20 {
+		return 0, nil, false
+	}
+	lockTime := int64(0)
+	if op := instrs[0].Op; op >= OP_1 && op <= OP_16 {
+		lockTime = int64(op-OP_1) + 1
+	} else if op <= OP_PUSHDATA1 {
+		lockTime, err = DecodeScriptNum(instrs[0].Data, maxNumSize+1)
+	}
+	pubKeyHash := instrs[5].Data
+	if err != nil || !bytes.Equal(s, TimeLockScript(int(lockTime), pubKeyHash)) {
+		return 0, nil, false
+	}
+	return int(lockTime), pubKeyHash, true
+}
+
+func (s Script) ScriptHash() []byte {
+	if len(s) == 23 && s[0] == OP_HASH160 && s[1] == 20 && s[22] == OP_EQUAL {
+		return s[2:22]
+	}
+	return nil
+}
+
+func (s Script) Multisig() (int, [][]byte, bool) {
+	instrs, err := s.Parse()
+	if err != nil || len(instrs) < 4 || instrs[len(instrs)-1].Op != OP_CHECKMULTISIG {
+		return 0, nil, false
+	}
+	m, n := int(instrs[0].Op)-OP_1+1, int(instrs[len(instrs)-2].Op)-OP_1+1
+	if m < 1 || m > 16 || n < m || n > 16 || len(instrs) != n+3 {
+		return 0, nil, false
+	}
+	pubKeys := make([][]byte, 0, n)
+	for _, instr := range instrs[1 : n+1] {
+		if len(instr.Data) != 64 {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, instr.Data)
+	}
+	return m, pubKeys, true
+}
+
+func (s Script) String() string {
+	instrs, err := s.Parse()
+	if err != nil {
+		return "[error]"
+	}
+	words := make([]string, 0, len(instrs))
+	for _, instr := range instrs {
+		switch {
+		case instr.Data != nil:
+			words = append(words, hex.EncodeToString(instr.Data))
+		case instr.Op >= OP_1 && instr.Op <= OP_16:
+			words = append(words, fmt.Sprintf("OP_%d", instr.Op-OP_1+1))
+		case opNames[instr.Op] != "":
+			words = append(words, opNames[instr.Op])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN%d", instr.Op))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func PayToPubKeyHash(pubKeyHash []byte) Script {
+	return Script{}.
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG)
+}
+
+func PayToScriptHash(scriptHash []byte) Script {
+	return Script{}.
+		AddOp(OP_HASH160).
+		AddData(scriptHash).
+		AddOp(OP_EQUAL)
+}
+
+func MultisigScript(m int, pubKeys [][]byte) Script {
+	s := Script{}.AddInt(int64(m))
+	for _, pubKey := range pubKeys {
+		s = s.AddData(pubKey)
+	}
+	return s.AddInt(int64(len(pubKeys))).AddOp(OP_CHECKMULTISIG)
+}
+
+func HashLockScript(hash, pubKeyHash []byte) Script {
+	return Script{}.
+		AddOp(OP_SHA256).
+		AddData(hash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG)
+}
+
+func TimeLockScript(lockTime int, pubKeyHash []byte) Script {
+	return Script{}.
+		AddInt(int64(lockTime)).
+		AddOp(OP_CHECKLOCKTIMEVERIFY).
+		AddOp(OP_DROP).
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG)
+}
+
+func EncodeScriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	result := make([]byte, 0, 9)
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if neg {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if neg {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+func DecodeScriptNum(b []byte, maxSize int) (int64, error) {
+	if len(b) > maxSize {
+		return 0, errors.New("number overflow")
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if b[len(b)-1]&0x7f == 0 && (len(b) == 1 || b[len(b)-2]&0x80 == 0) {
+		return 0, errors.New("non-minimal number encoding")
+	}
+	var n int64
+	for i, v := range b {
+		n |= int64(v) << (8 * i)
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		n &^= int64(0x80) << (8 * (len(b) - 1))
+		return -n, nil
+	}
+	return n, nil
+}
+
+func scriptBool(b []byte) bool {
+	for i, v := range b {
+		if v != 0 {
+			return !(i == len(b)-1 && v == 0x80)
+		}
+	}
+	return false
+}
+
+type ScriptVM struct {
+	Tx    *Tx
+	Idx   int
+	stack [][]byte
+	ops   int
+}
+
+func NewScriptVM(tx *Tx, idx int) *ScriptVM {
+	return &ScriptVM{Tx: tx, Idx: idx}
+}
+
+func (vm *ScriptVM) push(data []byte) error {
+	if len(data) > maxElementSize {
+		return errors.New("element size limit exceeded")
+	}
+	if len(vm.stack) >= maxStackSize {
+		return errors.New("stack size limit exceeded")
+	}
+	vm.stack = append(vm.stack, data)
+	return nil
+}
+
+func (vm *ScriptVM) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, errors.New("stack underflow")
+	}
+	top := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return top, nil
+}
+
+func (vm *ScriptVM) popInt() (int64, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+	return DecodeScriptNum(data, maxNumSize)
+}
+
+func (vm *ScriptVM) pushBool(v bool) error {
+	if v {
+		return vm.push([]byte{1})
+	}
+	return vm.push(nil)
+}
+
+func (vm *ScriptVM) Run(s Script) error {
+	if len(s) > maxScriptSize {
+		return errors.New("script size limit exceeded")
+	}
+	instrs, err := s.Parse()
+	if err != nil {
+		return err
+	}
+	vm.ops = 0
+	conds := make([]bool, 0)
+	for _, instr := range instrs {
+		executing := true
+		for _, cond := range conds {
+			executing = executing && cond
+		}
+		if instr.Op > OP_16 {
+			vm.ops++
+			if vm.ops > maxScriptOps {
+				return errors.New("operation limit exceeded")
+			}
+		}
+		switch instr.Op {
+		case OP_IF, OP_NOTIF:
+			value := false
+			if executing {
+				data, err := vm.pop()
+				if err != nil {
+					return err
+				}
+				value = scriptBool(data) == (instr.Op == OP_IF)
+			}
+			conds = append(conds, value)
+			continue
+		case OP_ELSE:
+			if len(conds) == 0 {
+				return errors.New("unbalanced conditional")
+			}
+			conds[len(conds)-1] = !conds[len(conds)-1]
+			continue
+		case OP_ENDIF:
+			if len(conds) == 0 {
+				return errors.New("unbalanced conditional")
+			}
+			conds = conds[:len(conds)-1]
+			continue
+		}
+		if !executing {
+			continue
+		}
+		if err := vm.step(instr); err != nil {
+			return fmt.Errorf("%v: %v", opName(instr), err)
+		}
+	}
+	if len(conds) != 0 {
+		return errors.New("unbalanced conditional")
+	}
+	return nil
+}
+
+func opName(instr Instruction) string {
+	if instr.Op <= OP_16 {
+		return "push"
+	}
+	if name, ok := opNames[instr.Op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP_UNKNOWN%d", instr.Op)
+}
+
+func (vm *ScriptVM) step(instr Instruction) error {
+	switch op := instr.Op; {
+	case op == OP_0:
+		return vm.push(nil)
+	case op <= OP_PUSHDATA2:
+		return vm.push(instr.Data)
+	case op == OP_1NEGATE:
+		return vm.push(EncodeScriptNum(-1))
+	case op >= OP_1 && op <= OP_16:
+		return vm.push(EncodeScriptNum(int64(op - OP_1 + 1)))
+	case op == OP_NOP:
+		return nil
+	case op == OP_VERIFY:
+		return vm.verify()
+	case op == OP_RETURN:
+		return errors.New("script returned early")
+	case op == OP_DROP:
+		_, err := vm.pop()
+		return err
+	case op == OP_DUP:
+		top, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.stack = append(vm.stack, top)
+		return vm.push(top)
+	case op == OP_SWAP:
+		if len(vm.stack) < 2 {
+			return errors.New("stack underflow")
+		}
+		n := len(vm.stack)
+		vm.stack[n-1], vm.stack[n-2] = vm.stack[n-2], vm.stack[n-1]
+		return nil
+	case op == OP_SIZE:
+		if len(vm.stack) < 1 {
+			return errors.New("stack underflow")
+		}
+		return vm.push(EncodeScriptNum(int64(len(vm.stack[len(vm.stack)-1]))))
+	case op == OP_EQUAL || op == OP_EQUALVERIFY:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if err := vm.pushBool(bytes.Equal(a, b)); err != nil {
+			return err
+		}
+		if op == OP_EQUALVERIFY {
+			return vm.verify()
+		}
+		return nil
+	case op == OP_SHA256:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		return vm.push(hash[:])
+	case op == OP_HASH160:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		return vm.push(Hash160(data))
+	case op == OP_CHECKSIG || op == OP_CHECKSIGVERIFY:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if err := vm.pushBool(vm.checkSig(sig, pubKey)); err != nil {
+			return err
+		}
+		if op == OP_CHECKSIGVERIFY {
+			return vm.verify()
+		}
+		return nil
+	case op == OP_CHECKMULTISIG || op == OP_CHECKMULTISIGVERIFY:
+		if err := vm.checkMultisig(); err != nil {
+			return err
+		}
+		if op == OP_CHECKMULTISIGVERIFY {
+			return vm.verify()
+		}
+		return nil
+	case op == OP_CHECKLOCKTIMEVERIFY:
+		return vm.checkLockTime()
+	}
+	return errors.New("unknown opcode")
+}
+
+func (vm *ScriptVM) verify() error {
+	top, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if !scriptBool(top) {
+		return errors.New("verify failed")
+	}
+	return nil
+}
+
+func (vm *ScriptVM) checkSig(sig, pubKey []byte) bool {
+	if len(sig) < 1 {
+		return false
+	}
+	flag := SigHashType(sig[len(sig)-1])
+	hash := vm.Tx.SigHash(vm.Idx, flag)
+	return hash != nil && VerifySignature(pubKey, hash, sig[:len(sig)-1])
+}
+
+func (vm *ScriptVM) checkMultisig() error {
+	n, err := vm.popInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > maxMultisigKeys {
+		return errors.New("invalid public key count")
+	}
+	vm.ops += int(n)
+	if vm.ops > maxScriptOps {
+		return errors.New("operation limit exceeded")
+	}
+	pubKeys := make([][]byte, n)
+	for i := range pubKeys {
+		if pubKeys[i], err = vm.pop(); err != nil {
+			return err
+		}
+	}
+	m, err := vm.popInt()
+	if err != nil {
+		return err
+	}
+	if m < 0 || m > n {
+		return errors.New("invalid signature count")
+	}
+	sigs := make([][]byte, m)
+	for i := range sigs {
+		if sigs[i], err = vm.pop(); err != nil {
+			return err
+		}
+	}
+	// Signatures and keys are popped in reverse, so both lists
+	// are walked from the end to keep the order of the script.
+	success := true
+	for i, k := len(sigs)-1, len(pubKeys)-1; i >= 0; k-- {
+		if i > k {
+			success = false
+			break
+		}
+		if vm.checkSig(sigs[i], pubKeys[k]) {
+			i--
+		}
+	}
+	return vm.pushBool(success)
+}
+
+func (vm *ScriptVM) checkLockTime() error {
+	if len(vm.stack) < 1 {
+		return errors.New("stack underflow")
+	}
+	lockTime, err := DecodeScriptNum(vm.stack[len(vm.stack)-1], maxNumSize+1)
+	if err != nil {
+		return err
+	}
+	if lockTime < 0 {
+		return errors.New("negative lock time")
+	}
+	txLockTime := int64(vm.Tx.LockTime)
+	if (lockTime < lockTimeThreshold) != (txLockTime < lockTimeThreshold) {
+		return errors.New("lock time type mismatch")
+	}
+	if lockTime > txLockTime {
+		return errors.New("lock time not reached")
+	}
+	return nil
+}
+
+func VerifyScript(unlock, lock Script, tx *Tx, idx int) error {
+	if !unlock.PushOnly() {
+		return errors.New("unlocking script is not push only")
+	}
+	vm := NewScriptVM(tx, idx)
+	if err := vm.Run(unlock); err != nil {
+		return err
+	}
+	stack := append([][]byte(nil), vm.stack...)
+	if err := vm.Run(lock); err != nil {
+		return err
+	}
+	if err := vm.verify(); err != nil {
+		return errors.New("script evaluated to false")
+	}
+	if lock.ScriptHash() == nil {
+		return nil
+	}
+	vm.stack = stack
+	redeem, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if err := vm.Run(redeem); err != nil {
+		return err
+	}
+	if err := vm.verify(); err != nil {
+		return errors.New("redeem script evaluated to false")
+	}
+	return nil
+}
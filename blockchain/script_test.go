@@ -0,0 +1,98 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+// signInput returns signature of input 0 of tx spending an output.
+func signInput(t *testing.T, tx *Tx, w *Wallet) []byte {
+	sig, err := tx.Signature(0, w, SigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestVerifyScript(t *testing.T) {
+	alice, bob, carol := newTestWallet(), newTestWallet(), newTestWallet()
+	tx, _ := testTx(alice)
+	tx.LockTime = 100
+	secret := []byte("secret")
+	hash := sha256.Sum256(secret)
+	multisig := MultisigScript(2, [][]byte{alice.PubKey(), bob.PubKey(), carol.PubKey()})
+	p2sh := PayToScriptHash(Hash160(multisig))
+	sigA, sigB, sigC := signInput(t, tx, alice), signInput(t, tx, bob), signInput(t, tx, carol)
+	tests := []struct {
+		name   string
+		unlock Script
+		lock   Script
+		valid  bool
+	}{
+		{"pay to public key hash", Script{}.AddData(sigA).AddData(alice.PubKey()), alice.LockScript(), true},
+		{"wrong key", Script{}.AddData(sigB).AddData(bob.PubKey()), alice.LockScript(), false},
+		{"wrong signature", Script{}.AddData(sigB).AddData(alice.PubKey()), alice.LockScript(), false},
+		{"multisig", Script{}.AddOp(OP_0).AddData(sigA).AddData(sigC).AddData(multisig), p2sh, true},
+		{"multisig out of order", Script{}.AddOp(OP_0).AddData(sigC).AddData(sigA).AddData(multisig), p2sh, false},
+		{"multisig twice by one key", Script{}.AddOp(OP_0).AddData(sigA).AddData(sigA).AddData(multisig), p2sh, false},
+		{"multisig wrong redeem", Script{}.AddOp(OP_0).AddData(sigA).AddData(sigB).AddData(alice.LockScript()), p2sh, false},
+		{"hash lock", Script{}.AddData(sigA).AddData(alice.PubKey()).AddData(secret),
+			HashLockScript(hash[:], alice.PubKeyHash()), true},
+		{"hash lock wrong secret", Script{}.AddData(sigA).AddData(alice.PubKey()).AddData([]byte("guess")),
+			HashLockScript(hash[:], alice.PubKeyHash()), false},
+		{"time lock", Script{}.AddData(sigA).AddData(alice.PubKey()), TimeLockScript(100, alice.PubKeyHash()), true},
+		{"time lock not reached", Script{}.AddData(sigA).AddData(alice.PubKey()), TimeLockScript(101, alice.PubKeyHash()), false},
+		{"time lock type mismatch", Script{}.AddData(sigA).AddData(alice.PubKey()),
+			TimeLockScript(lockTimeThreshold, alice.PubKeyHash()), false},
+		{"unlocking script not push only", Script{}.AddData(sigA).AddData(alice.PubKey()).AddOp(OP_NOP), alice.LockScript(), false},
+		{"return", Script{}, Script{}.AddOp(OP_RETURN), false},
+		{"empty stack", Script{}, Script{}, false},
+		{"if else", Script{}.AddOp(OP_0), Script{}.AddOp(OP_IF).AddOp(OP_0).AddOp(OP_ELSE).AddInt(1).AddOp(OP_ENDIF), true},
+		{"unbalanced if", Script{}.AddInt(1), Script{}.AddOp(OP_IF).AddInt(1), false},
+		{"unknown opcode", Script{}.AddInt(1), Script{}.AddOp(0xff), false},
+		{"unsupported OP_PUSHDATA4", Script{}, Script{}.AddOp(0x4e).AddInt(1), false},
+	}
+	for _, test := range tests {
+		err := VerifyScript(test.unlock, test.lock, tx, 0)
+		if (err == nil) != test.valid {
+			t.Errorf("%v: VerifyScript = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestScriptNum(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 16, 127, 128, -128, 255, 256, 32767, -32768, 1<<31 - 1, -(1<<31 - 1)} {
+		got, err := DecodeScriptNum(EncodeScriptNum(n), maxNumSize)
+		if err != nil || got != n {
+			t.Errorf("DecodeScriptNum(EncodeScriptNum(%v)) = %v, %v", n, got, err)
+		}
+	}
+	for _, b := range [][]byte{{0x00}, {0x80}, {0x01, 0x00}, {0x7f, 0x80}} {
+		if _, err := DecodeScriptNum(b, maxNumSize); err == nil {
+			t.Errorf("non-minimal number %x is decoded", b)
+		}
+	}
+	if _, err := DecodeScriptNum([]byte{1, 2, 3, 4, 5}, maxNumSize); err == nil {
+		t.Error("number longer than maxNumSize is decoded")
+	}
+}
+
+func TestScriptParse(t *testing.T) {
+	s := Script{}.AddData(make([]byte, 75)).AddData(make([]byte, 76)).AddData(make([]byte, 256)).AddInt(-1).AddInt(16)
+	instrs, err := s.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := []byte{75, OP_PUSHDATA1, OP_PUSHDATA2, OP_1NEGATE, OP_16}
+	if len(instrs) != len(ops) {
+		t.Fatalf("parsed %v instructions, want %v", len(instrs), len(ops))
+	}
+	for i, op := range ops {
+		if instrs[i].Op != op {
+			t.Errorf("instruction %v is %#x, want %#x", i, instrs[i].Op, op)
+		}
+	}
+	if _, err := (Script{OP_PUSHDATA1, 2, 0}).Parse(); err == nil {
+		t.Error("truncated push is parsed")
+	}
+}
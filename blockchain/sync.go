@@ -0,0 +1,535 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxHeaders bounds headers served in a single response.
+	maxHeaders = 2000
+	// syncBatch is the number of block bodies downloaded in parallel before
+	// they are connected.
+	syncBatch   = 64
+	syncTimeout = 30 * time.Second
+	// locatorDense is the number of the last blocks of a locator, below which
+	// it skips exponentially more blocks.
+	locatorDense = 10
+	// maxLocator bounds hashes of a locator a peer accepts.
+	maxLocator = 64
+
+	DefaultSyncWorkers = 4
+)
+
+// MainHeaders returns headers of chain from the first block to the tip.
+func (bc *Blockchain) MainHeaders() []BlockHeader {
+	var headers []BlockHeader
+	bc.DB.BlockchainTip()
+	for block := bc.DB.NextBlock(); block != nil; block = bc.DB.NextBlock() {
+		headers = append(headers, block.Header)
+	}
+	slices.Reverse(headers)
+	return headers
+}
+
+// Locator returns hashes of chain from the tip back to the first block, one
+// for each of the last blocks and exponentially sparser below them, so that
+// a peer finds the last block it has in common with chain.
+func Locator(chain []BlockHeader) [][]byte {
+	var locator [][]byte
+	step := 1
+	for i := len(chain) - 1; i >= 0; i -= step {
+		locator = append(locator, chain[i].Hash)
+		if len(locator) >= locatorDense {
+			step *= 2
+		}
+	}
+	if len(chain) > 0 && !bytes.Equal(locator[len(locator)-1], chain[0].Hash) {
+		locator = append(locator, chain[0].Hash)
+	}
+	return locator
+}
+
+// HeadersAfter returns up to count headers of chain after the first locator
+// hash in it, or from the first block when chain has none of them.
+func (bc *Blockchain) HeadersAfter(locator [][]byte, count int) []BlockHeader {
+	chain := bc.MainHeaders()
+	heights := make(map[string]int)
+	for _, h := range chain {
+		heights[fmt.Sprintf("%x", h.Hash)] = h.Height
+	}
+	fork := -1
+	for _, hash := range locator {
+		if height, ok := heights[fmt.Sprintf("%x", hash)]; ok {
+			fork = height
+			break
+		}
+	}
+	return chain[fork+1 : min(fork+1+count, len(chain))]
+}
+
+// Work is the expected number of hashes to find header, and 1 for headers
+// of engines which sign blocks.
+func (h *BlockHeader) Work() *big.Int {
+	if h.Version != blockVersion {
+		return big.NewInt(1)
+	}
+	target := new(big.Int).SetBytes(Target(difficulty))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), target.Add(target, big.NewInt(1)))
+}
+
+func ChainWork(headers []BlockHeader) *big.Int {
+	work := new(big.Int)
+	for i := range headers {
+		work.Add(work, headers[i].Work())
+	}
+	return work
+}
+
+type HeadersResponse struct {
+	Height  int      `json:"height"`
+	Headers []string `json:"headers"`
+}
+
+func (s *Server) getHeaders(w http.ResponseWriter, r *http.Request) {
+	var locator [][]byte
+	if param := r.URL.Query().Get("locator"); param != "" {
+		for _, hash := range strings.Split(param, ",") {
+			data, err := hex.DecodeString(hash)
+			if err != nil || len(data) != hashSize || len(locator) == maxLocator {
+				s.misbehave(r, ScoreMalformed, "malformed locator")
+				writeError(w, http.StatusBadRequest, errors.New("invalid locator"))
+				return
+			}
+			locator = append(locator, data)
+		}
+	}
+	resp := &HeadersResponse{Headers: []string{}}
+	err := s.withChain(func(bc *Blockchain, u *UTXOSet) error {
+		resp.Height = bc.Height()
+		for _, h := range bc.HeadersAfter(locator, maxHeaders) {
+			resp.Headers = append(resp.Headers, fmt.Sprintf("%x", h.Bytes()))
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) getBlock(w http.ResponseWriter, r *http.Request) {
+	hash, err := decodeHex(r.URL.Query().Get("hash"), func(data []byte) ([]byte, error) { return data, nil })
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var block *Block
+	err = s.withChain(func(bc *Blockchain, u *UTXOSet) error {
+		block = bc.DB.Block(hash)
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if block == nil {
+		writeError(w, http.StatusNotFound, errors.New("unknown block"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"block": fmt.Sprintf("%x", block.Bytes())})
+}
+
+// SyncStatus is progress of the last sync, kept in database so that it can be
+// read while sync is running.
+type SyncStatus struct {
+	State        string
+	Peers        []string
+	StartHeight  int
+	TargetHeight int
+	HeaderHeight int
+	BlockHeight  int
+	Started      int
+	Updated      int
+	Error        string
+}
+
+func (s *SyncStatus) Serialize() []byte {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(s)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func SyncStatusDeserialize(data []byte) *SyncStatus {
+	s := &SyncStatus{}
+	buf := bytes.NewBuffer(data)
+	dec := gob.NewDecoder(buf)
+	err := dec.Decode(s)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Syncer downloads the chain from peers headers first: it fetches and
+// validates the header chain with the most work among peers, moves the tip
+// back to the fork point when the chain forks off the local one, then
+// downloads block bodies in parallel from all peers, and connects them in order. Database is opened
+// only to connect blocks and to record status and misbehaviour of peers.
+type Syncer struct {
+	Peers   []string
+	Workers int
+	Status  SyncStatus
+	heights map[string]int
+	mu      sync.Mutex
+	pending []misbehaviour
+}
+
+type misbehaviour struct {
+	peer   string
+	score  int
+	reason string
+}
+
+func (s *Syncer) misbehave(peer string, score int, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, misbehaviour{peer, score, reason})
+}
+
+func NewSyncer(peers []string, workers int) *Syncer {
+	return &Syncer{Peers: peers, Workers: max(workers, 1), heights: make(map[string]int)}
+}
+
+func (s *Syncer) withChain(fn func(bc *Blockchain, u *UTXOSet) error) error {
+	db := GetDatabase()
+	defer db.Close()
+	bc := db.Blockchain()
+	u := db.UTXOSet()
+	if u == nil {
+		u = new(UTXOSet)
+		*u = make(UTXOSet)
+		u.Index(bc)
+		db.SetUTXOSet(u)
+	}
+	err := fn(bc, u)
+	s.mu.Lock()
+	if len(s.pending) > 0 {
+		am := db.AddrMan()
+		if am == nil {
+			am = new(AddrMan)
+			*am = make(AddrMan)
+		}
+		for _, m := range s.pending {
+			if am.Misbehave(m.peer, "sync", m.score, m.reason) {
+				fmt.Printf("Banned %v: %v\n", m.peer, m.reason)
+			}
+		}
+		db.SetAddrMan(am)
+		s.pending = nil
+	}
+	s.mu.Unlock()
+	s.Status.Updated = int(time.Now().Unix())
+	if err != nil && s.Status.Error == "" {
+		s.Status.State, s.Status.Error = "failed", err.Error()
+	}
+	db.SetSyncStatus(&s.Status)
+	return err
+}
+
+func (s *Syncer) Sync(ctx context.Context) error {
+	var chain []BlockHeader
+	now := int(time.Now().Unix())
+	s.Status = SyncStatus{State: "headers", Peers: s.Peers, Started: now}
+	err := s.withChain(func(bc *Blockchain, u *UTXOSet) error {
+		chain = bc.MainHeaders()
+		s.Status.StartHeight = len(chain) - 1
+		s.Status.TargetHeight = s.Status.StartHeight
+		s.Status.HeaderHeight, s.Status.BlockHeight = s.Status.StartHeight, s.Status.StartHeight
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	best, err := s.syncHeaders(ctx, chain)
+	if err == nil && best != nil {
+		err = s.syncBlocks(ctx, chain, best)
+	}
+	if err != nil {
+		s.withChain(func(bc *Blockchain, u *UTXOSet) error { return err })
+		return err
+	}
+	s.Status.State = "done"
+	return s.withChain(func(bc *Blockchain, u *UTXOSet) error { return nil })
+}
+
+// PeerChain is the header chain of a peer after the last block it has in
+// common with local chain, at height Fork, or -1 when there is none.
+type PeerChain struct {
+	Peer    string
+	Fork    int
+	Headers []BlockHeader
+	// Work is cumulative work of local chain up to Fork and of Headers.
+	Work *big.Int
+}
+
+// syncHeaders fetches headers after the last common block from every peer
+// and returns the chain of the peer with the most cumulative work, or nil
+// when no peer has more work than local chain. Peers which fail are skipped.
+func (s *Syncer) syncHeaders(ctx context.Context, chain []BlockHeader) (*PeerChain, error) {
+	locator := Locator(chain)
+	heights := map[string]int{"": -1}
+	for _, h := range chain {
+		heights[fmt.Sprintf("%x", h.Hash)] = h.Height
+	}
+	local := ChainWork(chain)
+	var best *PeerChain
+	reachable := 0
+	for _, peer := range s.Peers {
+		c, err := s.fetchHeaders(ctx, peer, locator, heights)
+		if err != nil {
+			fmt.Printf("Failed to Sync Headers from Peer %v: %v\n", peer, err)
+			continue
+		}
+		reachable++
+		c.Work.Add(c.Work, ChainWork(chain[:c.Fork+1]))
+		if c.Work.Cmp(local) > 0 && (best == nil || c.Work.Cmp(best.Work) > 0) {
+			best = c
+		}
+	}
+	if reachable == 0 {
+		return nil, errors.New("no peers are reachable")
+	}
+	if best != nil {
+		s.Status.TargetHeight = best.Fork + len(best.Headers)
+	}
+	return best, nil
+}
+
+// fetchHeaders fetches headers of peer after the last of locator hashes it
+// has, which must be in local chain at heights, and checks them.
+func (s *Syncer) fetchHeaders(ctx context.Context, peer string, locator [][]byte, heights map[string]int) (*PeerChain, error) {
+	c := &PeerChain{Peer: peer, Fork: -1, Work: new(big.Int)}
+	var prevHash []byte
+	height := -1
+	for {
+		hashes := make([]string, len(locator))
+		for i, hash := range locator {
+			hashes[i] = fmt.Sprintf("%x", hash)
+		}
+		var resp HeadersResponse
+		if err := syncRequest(ctx, fmt.Sprintf("%v/getheaders?locator=%v", peer, strings.Join(hashes, ",")), &resp); err != nil {
+			return nil, err
+		}
+		s.heights[peer] = resp.Height
+		for _, data := range resp.Headers {
+			h, err := decodeHex(data, DecodeBlockHeader)
+			if err != nil {
+				s.misbehave(peer, ScoreMalformed, "malformed header")
+				return nil, fmt.Errorf("malformed header at height %v: %v", height+1, err)
+			}
+			if len(c.Headers) == 0 {
+				fork, ok := heights[fmt.Sprintf("%x", h.PrevHash)]
+				if !ok {
+					return nil, errors.New("headers do not connect to local chain")
+				}
+				c.Fork, prevHash, height = fork, h.PrevHash, fork
+			}
+			if err := CheckHeader(h, prevHash, height+1); err != nil {
+				if score := headerScore(err); score > 0 {
+					s.misbehave(peer, score, "invalid header")
+				}
+				return nil, fmt.Errorf("invalid header at height %v: %v", height+1, err)
+			}
+			c.Headers = append(c.Headers, *h)
+			c.Work.Add(c.Work, h.Work())
+			prevHash, height = h.Hash, h.Height
+		}
+		s.Status.HeaderHeight = max(height, s.Status.StartHeight)
+		if err := s.withChain(func(bc *Blockchain, u *UTXOSet) error { return nil }); err != nil {
+			return nil, err
+		}
+		if len(resp.Headers) < maxHeaders {
+			return c, nil
+		}
+		locator = [][]byte{prevHash}
+	}
+}
+
+var (
+	errHeaderLink    = errors.New("header does not extend the chain")
+	errHeaderVersion = errors.New("unexpected block version")
+	errHeaderPoW     = errors.New("proof of work is above target")
+)
+
+// headerScore is misbehaviour score of a peer which sent header failing
+// CheckHeader with err. Timestamps in the future may be clock skew, so only
+// malformed headers and proof of work are scored.
+func headerScore(err error) int {
+	switch err {
+	case errHeaderPoW:
+		return ScoreInvalidBlock
+	case errHeaderLink, errHeaderVersion:
+		return ScoreMalformed
+	}
+	return 0
+}
+
+// CheckHeader checks a header which follows prevHash at height without its
+// block body. Seals of signed blocks are checked when the block is connected.
+func CheckHeader(h *BlockHeader, prevHash []byte, height int) error {
+	_, pow := params.Engine().(PoWEngine)
+	switch {
+	case !bytes.Equal(h.PrevHash, prevHash) || h.Height != height:
+		return errHeaderLink
+	case pow && h.Version != blockVersion || !pow && h.Version != sealedBlockVersion:
+		return errHeaderVersion
+	case h.Timestamp > int(time.Now().Unix())+maxFutureDrift:
+		return errors.New("header timestamp is too far in the future")
+	case pow && bytes.Compare(h.PoWHash(), Target(difficulty)) >= 0:
+		return errHeaderPoW
+	}
+	return nil
+}
+
+// syncBlocks downloads bodies of headers of best chain in batches, spreading
+// them among peers which have them, and connects every batch in order. When
+// best chain forks below the tip, local blocks after the fork point are
+// disconnected before the first batch is connected, and reconnected if best
+// chain fails to get more work.
+func (s *Syncer) syncBlocks(ctx context.Context, chain []BlockHeader, best *PeerChain) error {
+	s.Status.State = "blocks"
+	var fork []byte
+	if best.Fork >= 0 {
+		fork = chain[best.Fork].Hash
+	}
+	disconnected := false
+	err := s.connectBlocks(ctx, best.Headers, func(bc *Blockchain, u *UTXOSet) {
+		if best.Fork < len(chain)-1 {
+			fmt.Printf("Disconnecting %v blocks after height %v\n", len(chain)-1-best.Fork, best.Fork)
+			bc.SetTip(fork, u)
+			s.Status.BlockHeight, disconnected = best.Fork, true
+		}
+	})
+	if err != nil && disconnected {
+		s.withChain(func(bc *Blockchain, u *UTXOSet) error {
+			if ChainWork(bc.MainHeaders()).Cmp(ChainWork(chain)) < 0 {
+				fmt.Println("Reconnecting blocks of previous chain")
+				bc.SetTip(chain[len(chain)-1].Hash, u)
+				s.Status.BlockHeight = len(chain) - 1
+			}
+			return nil
+		})
+	}
+	return err
+}
+
+// connectBlocks downloads and connects blocks of headers, calling before
+// first on the chain before the first block is connected.
+func (s *Syncer) connectBlocks(ctx context.Context, headers []BlockHeader, before func(bc *Blockchain, u *UTXOSet)) error {
+	for start := 0; start < len(headers); start += syncBatch {
+		batch := headers[start:min(start+syncBatch, len(headers))]
+		blocks := make([]*Block, len(batch))
+		origins := make([]string, len(batch))
+		errs := make([]error, len(batch))
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for worker := range s.Workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					blocks[i], origins[i], errs[i] = s.fetchBlock(ctx, &batch[i], worker+i)
+				}
+			}()
+		}
+		for i := range batch {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		err := s.withChain(func(bc *Blockchain, u *UTXOSet) error {
+			if start == 0 {
+				before(bc, u)
+			}
+			for i, block := range blocks {
+				if err := bc.SubmitBlock(block, u); err != nil {
+					s.misbehave(origins[i], ScoreInvalidBlock, "invalid block")
+					return fmt.Errorf("failed to connect block %v from %v: %v", block.Header.Height, origins[i], err)
+				}
+				s.Status.BlockHeight = block.Header.Height
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchBlock downloads body of header, trying peers which have it in turn
+// starting from the one selected by offset, and returns the peer it came from.
+func (s *Syncer) fetchBlock(ctx context.Context, h *BlockHeader, offset int) (*Block, string, error) {
+	var err error
+	for i := range s.Peers {
+		peer := s.Peers[(offset+i)%len(s.Peers)]
+		if s.heights[peer] < h.Height {
+			continue
+		}
+		var resp struct{ Block string }
+		if err = syncRequest(ctx, fmt.Sprintf("%v/getblock?hash=%x", peer, h.Hash), &resp); err != nil {
+			continue
+		}
+		var block *Block
+		if block, err = decodeHex(resp.Block, DecodeBlock); err != nil {
+			s.misbehave(peer, ScoreMalformed, "malformed block")
+			err = fmt.Errorf("peer %v sent malformed block: %v", peer, err)
+			continue
+		}
+		if !bytes.Equal(block.Header.Hash, h.Hash) || !bytes.Equal(block.Header.MerkleRoot, block.Txs.MerkleRoot()) {
+			s.misbehave(peer, ScoreInvalidBlock, "block does not match header")
+			err = fmt.Errorf("peer %v sent block which does not match header %x", peer, h.Hash)
+			continue
+		}
+		return block, peer, nil
+	}
+	if err == nil {
+		err = errors.New("no peer has the block")
+	}
+	return nil, "", fmt.Errorf("failed to download block %v: %v", h.Height, err)
+}
+
+func syncRequest(ctx context.Context, url string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, v)
+}
@@ -0,0 +1,117 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLocator(t *testing.T) {
+	chain := make([]BlockHeader, 100)
+	for i := range chain {
+		chain[i] = BlockHeader{Height: i, Hash: []byte{byte(i)}}
+	}
+	locator := Locator(chain)
+	for i := range locatorDense {
+		if !bytes.Equal(locator[i], chain[len(chain)-1-i].Hash) {
+			t.Fatalf("hash %v of locator is %x, want block %v", i, locator[i], len(chain)-1-i)
+		}
+	}
+	if !bytes.Equal(locator[len(locator)-1], chain[0].Hash) {
+		t.Error("locator does not end with the first block")
+	}
+	if len(locator) > locatorDense+8 {
+		t.Errorf("locator of %v blocks has %v hashes", len(chain), len(locator))
+	}
+	if len(Locator(nil)) != 0 || len(Locator(chain[:1])) != 1 {
+		t.Error("locator of chain without blocks or with one block")
+	}
+}
+
+func TestCheckHeaderScore(t *testing.T) {
+	p := params
+	p.Consensus = "pow"
+	testChain(t, p)
+	h := &BlockHeader{Version: blockVersion, Timestamp: int(time.Now().Unix()), Height: 1, PrevHash: []byte{1}}
+	for h.Nonce = 0; bytes.Compare(h.PoWHash(), Target(difficulty)) < 0; h.Nonce++ {
+	}
+	future := *h
+	future.Timestamp += 2 * maxFutureDrift
+	sealed := *h
+	sealed.Version = sealedBlockVersion
+	tests := []struct {
+		name   string
+		h      *BlockHeader
+		prev   []byte
+		height int
+		score  int
+	}{
+		{"proof of work", h, []byte{1}, 1, ScoreInvalidBlock},
+		{"linkage", h, []byte{2}, 1, ScoreMalformed},
+		{"height", h, []byte{1}, 2, ScoreMalformed},
+		{"version", &sealed, []byte{1}, 1, ScoreMalformed},
+		{"future timestamp", &future, []byte{1}, 1, 0},
+	}
+	for _, test := range tests {
+		err := CheckHeader(test.h, test.prev, test.height)
+		if err == nil {
+			t.Errorf("%v: header is accepted", test.name)
+		} else if score := headerScore(err); score != test.score {
+			t.Errorf("%v: %v is scored %v, want %v", test.name, err, score, test.score)
+		}
+	}
+}
+
+func TestSetTip(t *testing.T) {
+	p := params
+	p.Consensus, p.CoinbaseMaturity = "pow", 1
+	bc := testChain(t, p)
+	alice, bob := newTestWallet(), newTestWallet()
+	u := make(UTXOSet)
+	mine := func(w *Wallet) *Block {
+		block, err := bc.Mine(context.Background(), w, &u, NewMiner(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+	mine(alice)
+	fork := mine(alice)
+	tx, _, err := bc.Send(alice, bob.LockScript(), Coin, 0, &u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tip := mine(alice)
+	if len(bc.Mempool.Entries) != 0 {
+		t.Fatal("mined transaction stays in mempool")
+	}
+
+	bc.SetTip(fork.Header.Hash, &u)
+	if !bytes.Equal(bc.LastHash(), fork.Header.Hash) {
+		t.Fatal("tip is not moved back to fork point")
+	}
+	if u.UTXO(tip.Txs[0].ID(), 0) != nil || u.UTXO(tx.ID(), 0) != nil {
+		t.Error("outputs of disconnected block are unspent")
+	}
+	if bc.Mempool.Entries[fmt.Sprintf("%x", tx.ID())] == nil {
+		t.Error("transaction of disconnected block does not return to mempool")
+	}
+	mine(bob)
+	mine(bob)
+	if bc.Height() != tip.Header.Height+1 || !bc.Verify() {
+		t.Error("chain does not verify after branch is mined")
+	}
+
+	bc.SetTip(tip.Header.Hash, &u)
+	if bc.Height() != tip.Header.Height || !bc.Verify() {
+		t.Error("chain does not verify after previous branch is restored")
+	}
+	if u.UTXO(tx.ID(), 0) == nil {
+		t.Error("outputs of reconnected block are not unspent")
+	}
+	if len(bc.Mempool.Entries) != 0 {
+		t.Error("transaction of reconnected block stays in mempool")
+	}
+}
@@ -3,168 +3,412 @@ package blockchain
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
+	"encoding/asn1"
+	"errors"
 	"fmt"
-	"reflect"
+	"math/big"
+	"strings"
+)
+
+const txVersion = 1
+
+const (
+	SequenceFinal = 0xffffffff
+	// SequenceRBF is the largest input sequence which signals that
+	// transaction may be replaced by a higher fee one.
+	SequenceRBF = 0xfffffffd
 )
 
 type Tx struct {
-	TxIn  []*TxIn
-	TxOut []*TxOut
+	TxIn     []*TxIn
+	TxOut    []*TxOut
+	LockTime int
 }
 
 func (tx *Tx) Bytes() []byte {
-	data := make([][]byte, 0)
+	e := &Encoder{}
+	e.WriteUint32(txVersion)
+	e.WriteVarInt(uint64(len(tx.TxIn)))
 	for _, txin := range tx.TxIn {
-		data = append(data, txin.Bytes())
+		e.Write(txin.Bytes())
 	}
+	e.WriteVarInt(uint64(len(tx.TxOut)))
 	for _, txout := range tx.TxOut {
-		data = append(data, txout.Bytes())
+		e.Write(txout.Bytes())
 	}
-	return bytes.Join(data, nil)
+	e.WriteUint32(uint32(tx.LockTime))
+	return e.Bytes()
 }
 
-func (tx *Tx) Hash() []byte {
-	hash := sha256.Sum256(tx.Bytes())
+func (tx *Tx) ID() []byte {
+	stripped := tx
+	if !tx.IsCoinBase() {
+		stripped = &Tx{nil, tx.TxOut, tx.LockTime}
+		for _, in := range tx.TxIn {
+			stripped.TxIn = append(stripped.TxIn, &TxIn{in.TxOutHash, in.TxOutIndex, nil, in.Sequence})
+		}
+	}
+	hash := sha256.Sum256(stripped.Bytes())
 	return hash[:]
 }
 
-func (tx *Tx) Trim() *Tx {
-	txcopy := new(Tx)
-	*txcopy = *tx
-	txcopy.TxIn = nil
-	for _, txIn := range tx.TxIn {
+func (tx *Tx) SigHash(idx int, flag SigHashType) []byte {
+	if idx < 0 || idx >= len(tx.TxIn) || !flag.Valid() {
+		return nil
+	}
+	txcopy := &Tx{LockTime: tx.LockTime}
+	for i, txIn := range tx.TxIn {
+		if flag.AnyoneCanPay() && i != idx {
+			continue
+		}
 		v := *txIn
+		v.Script = nil
+		if i != idx && flag.Base() != SigHashAll {
+			v.Sequence = 0
+		}
 		txcopy.TxIn = append(txcopy.TxIn, &v)
 	}
-	for _, in := range txcopy.TxIn {
-		in.Signature = nil
-		in.PubKey = nil
+	switch flag.Base() {
+	case SigHashAll:
+		txcopy.TxOut = tx.TxOut
+	case SigHashSingle:
+		if idx >= len(tx.TxOut) {
+			return nil
+		}
+		for i := 0; i < idx; i++ {
+			txcopy.TxOut = append(txcopy.TxOut, &TxOut{-1, nil})
+		}
+		txcopy.TxOut = append(txcopy.TxOut, tx.TxOut[idx])
 	}
-	return txcopy
+	hash := sha256.Sum256(append(txcopy.Bytes(), byte(flag)))
+	return hash[:]
 }
 
 func (tx *Tx) Sign(w *Wallet) {
+	for idx := range tx.TxIn {
+		if err := tx.SignInput(idx, w, SigHashAll); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (tx *Tx) SignInput(idx int, w *Wallet, flag SigHashType) error {
+	sig, err := tx.Signature(idx, w, flag)
+	if err != nil {
+		return err
+	}
+	tx.TxIn[idx].Script = Script{}.AddData(sig).AddData(w.PubKey())
+	return nil
+}
+
+// Signature signs input idx with flag. It fails for SINGLE when the input has
+// no output with the same index.
+func (tx *Tx) Signature(idx int, w *Wallet, flag SigHashType) ([]byte, error) {
+	hash := tx.SigHash(idx, flag)
+	if hash == nil {
+		return nil, fmt.Errorf("no signature hash of input %v with type %#x", idx, byte(flag))
+	}
+	return append(w.Sign(hash), byte(flag)), nil
+}
+
+// Sign returns ASN.1 encoded signature of hash with low S value.
+func (w *Wallet) Sign(hash []byte) []byte {
 	privateKey := (*ecdsa.PrivateKey)(w)
-	publicKey := (*ecdsa.PublicKey)(&w.PublicKey)
-	signature, err := ecdsa.SignASN1(rand.Reader, privateKey, tx.Trim().Hash())
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash)
 	if err != nil {
 		panic(err)
 	}
-	for _, in := range tx.TxIn {
-		in.Signature = signature
-		in.PubKey = append(
-			publicKey.X.Bytes(),
-			publicKey.Y.Bytes()...,
-		)
+	halfOrder := new(big.Int).Rsh(privateKey.Curve.Params().N, 1)
+	if s.Cmp(halfOrder) > 0 {
+		s.Sub(privateKey.Curve.Params().N, s)
+	}
+	signature, err := asn1.Marshal(ecdsaSignature{r, s})
+	if err != nil {
+		panic(err)
 	}
+	return signature
 }
 
-func (tx *Tx) SignedWith(w *Wallet) bool {
-	walletPubKey := (*ecdsa.PublicKey)(&w.PublicKey)
-	ok := true
+func VerifySignature(pubKey, hash, signature []byte) bool {
+	if len(pubKey) != 64 || !LowS(signature) {
+		return false
+	}
+	key := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     big.NewInt(0).SetBytes(pubKey[:32]),
+		Y:     big.NewInt(0).SetBytes(pubKey[32:]),
+	}
+	return ecdsa.VerifyASN1(key, hash, signature)
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func LowS(signature []byte) bool {
+	sig := ecdsaSignature{}
+	rest, err := asn1.Unmarshal(signature, &sig)
+	if err != nil || len(rest) != 0 || sig.S.Sign() <= 0 {
+		return false
+	}
+	halfOrder := new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+	return sig.S.Cmp(halfOrder) <= 0
+}
+
+func (tx *Tx) VerifyInput(idx int, prevOut *TxOut) bool {
+	return VerifyScript(tx.TxIn[idx].Script, prevOut.Script, tx, idx) == nil
+}
+
+func (tx *Tx) OutputValue() Amount {
+	value, err := tx.CheckAmounts()
+	if err != nil {
+		return MaxMoney + 1
+	}
+	return value
+}
+
+func (tx *Tx) CheckAmounts() (Amount, error) {
+	amounts := make([]Amount, 0, len(tx.TxOut))
+	for _, out := range tx.TxOut {
+		amounts = append(amounts, out.Value)
+	}
+	return SumAmounts(amounts...)
+}
+
+func (tx *Tx) SignalsRBF() bool {
 	for _, in := range tx.TxIn {
-		ok = ok && ecdsa.VerifyASN1(walletPubKey, tx.Trim().Hash(), in.Signature)
+		if in.Sequence <= SequenceRBF {
+			return true
+		}
+	}
+	return false
+}
+
+func (tx *Tx) IsCoinBase() bool {
+	return len(tx.TxIn) == 1 && tx.TxIn[0].TxOutHash == nil
+}
+
+func (tx *Tx) Final(height, timestamp int) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	if tx.LockTime < lockTimeThreshold {
+		return tx.LockTime <= height
 	}
-	return ok
+	return tx.LockTime <= timestamp
 }
 
 func (tx *Tx) Serialize() []byte {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(tx)
+	return tx.Bytes()
+}
+
+func TxDeserialize(data []byte) *Tx {
+	tx, err := DecodeTx(data)
 	if err != nil {
 		panic(err)
 	}
-	return buf.Bytes()
+	return tx
 }
 
-func TxDeserialize(data []byte) *Tx {
+func DecodeTx(data []byte) (*Tx, error) {
+	d := NewDecoder(data)
+	tx := d.ReadTx()
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if d.Remaining() != 0 {
+		return nil, errors.New("trailing data after transaction")
+	}
+	return tx, nil
+}
+
+func (d *Decoder) ReadTx() *Tx {
+	if v := d.ReadUint32(); d.Err() == nil && v != txVersion {
+		d.fail(errors.New("unsupported transaction version"))
+	}
 	tx := &Tx{}
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
-	err := dec.Decode(tx)
-	if err != nil {
-		panic(err)
+	n := d.ReadCount(10)
+	for i := 0; i < n; i++ {
+		in := &TxIn{}
+		in.TxOutHash = d.ReadBytes()
+		in.TxOutIndex = int(d.ReadUint32())
+		in.Script = d.ReadBytes()
+		in.Sequence = int(d.ReadUint32())
+		tx.TxIn = append(tx.TxIn, in)
+	}
+	n = d.ReadCount(9)
+	for i := 0; i < n; i++ {
+		tx.TxOut = append(tx.TxOut, d.ReadTxOut())
+	}
+	tx.LockTime = int(d.ReadUint32())
+	if d.Err() != nil {
+		return nil
 	}
 	return tx
 }
 
+func (d *Decoder) ReadTxOut() *TxOut {
+	out := &TxOut{}
+	out.Value = Amount(d.ReadInt64())
+	out.Script = d.ReadBytes()
+	return out
+}
+
+type SigHashType byte
+
+const (
+	SigHashAll          SigHashType = 0x01
+	SigHashNone         SigHashType = 0x02
+	SigHashSingle       SigHashType = 0x03
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+func (t SigHashType) Base() SigHashType {
+	return t &^ SigHashAnyoneCanPay
+}
+
+func (t SigHashType) AnyoneCanPay() bool {
+	return t&SigHashAnyoneCanPay != 0
+}
+
+func (t SigHashType) Valid() bool {
+	base := t.Base()
+	return base >= SigHashAll && base <= SigHashSingle
+}
+
+func ParseSigHashType(s string) (SigHashType, error) {
+	var flag SigHashType
+	base, modifier, _ := strings.Cut(strings.ToUpper(s), "|")
+	switch base {
+	case "ALL":
+		flag = SigHashAll
+	case "NONE":
+		flag = SigHashNone
+	case "SINGLE":
+		flag = SigHashSingle
+	default:
+		return 0, errors.New("unknown signature hash type")
+	}
+	switch modifier {
+	case "":
+	case "ANYONECANPAY":
+		flag |= SigHashAnyoneCanPay
+	default:
+		return 0, errors.New("unknown signature hash modifier")
+	}
+	return flag, nil
+}
+
 type TxIn struct {
 	TxOutHash  []byte
 	TxOutIndex int
-	Signature  []byte
-	PubKey     []byte
+	Script     Script
+	Sequence   int
 }
 
 func (in *TxIn) Bytes() []byte {
-	return bytes.Join([][]byte{
-		in.TxOutHash,
-		IntToBytes(in.TxOutIndex),
-		in.Signature,
-		in.PubKey,
-	}, nil)
+	e := &Encoder{}
+	e.WriteBytes(in.TxOutHash)
+	e.WriteUint32(uint32(in.TxOutIndex))
+	e.WriteBytes(in.Script)
+	e.WriteUint32(uint32(in.Sequence))
+	return e.Bytes()
 }
 
 type TxOut struct {
-	Value      int
-	PubKeyHash []byte
+	Value  Amount
+	Script Script
 }
 
 func (out *TxOut) Bytes() []byte {
-	return append(IntToBytes(out.Value), out.PubKeyHash...)
+	e := &Encoder{}
+	e.WriteInt64(int64(out.Value))
+	e.WriteBytes(out.Script)
+	return e.Bytes()
 }
 
-func (out *TxOut) LockedWith(w *Wallet) bool {
-	return reflect.DeepEqual([]byte(out.PubKeyHash), w.PubKeyHash())
+func (out *TxOut) LockedWith(lock Script) bool {
+	return bytes.Equal(out.Script, lock)
 }
 
 type Txs []*Tx
 
 func (txs Txs) Bytes() []byte {
-	data := make([][]byte, 0)
+	e := &Encoder{}
+	e.WriteVarInt(uint64(len(txs)))
 	for _, tx := range txs {
-		data = append(data, tx.Bytes())
+		e.Write(tx.Bytes())
 	}
-	return bytes.Join(data, nil)
+	return e.Bytes()
 }
 
-func (txs Txs) Hash() []byte {
-	hash := sha256.Sum256(txs.Bytes())
-	return hash[:]
+func (txs Txs) MerkleRoot() []byte {
+	hashes := make([][]byte, 0, len(txs))
+	for _, tx := range txs {
+		hashes = append(hashes, tx.ID())
+	}
+	return MerkleRoot(hashes)
 }
 
-func (txs Txs) Serialize() []byte {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(txs)
-	if err != nil {
-		panic(err)
+func (txs Txs) TxByID(txHash []byte) *Tx {
+	for _, tx := range txs {
+		if bytes.Equal(tx.ID(), txHash) {
+			return tx
+		}
+	}
+	return nil
+}
+
+func (txs Txs) TxOut(txHash []byte, idx int) *TxOut {
+	tx := txs.TxByID(txHash)
+	if tx == nil || idx < 0 || idx >= len(tx.TxOut) {
+		return nil
 	}
-	return buf.Bytes()
+	return tx.TxOut[idx]
+}
+
+func (txs Txs) Serialize() []byte {
+	return txs.Bytes()
 }
 
 func TxsDeserialize(data []byte) *Txs {
-	txs := &Txs{}
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
-	err := dec.Decode(txs)
-	if err != nil {
-		panic(err)
+	d := NewDecoder(data)
+	txs := d.ReadTxs()
+	if d.Err() == nil && d.Remaining() != 0 {
+		panic("TxsDeserialize: Trailing data after transactions")
+	}
+	if d.Err() != nil {
+		panic(d.Err())
+	}
+	return &txs
+}
+
+func (d *Decoder) ReadTxs() Txs {
+	txs := Txs{}
+	n := d.ReadCount(14)
+	for i := 0; i < n; i++ {
+		tx := d.ReadTx()
+		if tx == nil {
+			return nil
+		}
+		txs = append(txs, tx)
 	}
 	return txs
 }
 
-func (txs *Txs) UnspentTxOuts(spent map[string][]int) map[string][]*TxOut {
-	unspent := make(map[string][]*TxOut)
+func (txs *Txs) UnspentTxOuts(spent map[string][]int, height int) map[string]map[int]*UTXO {
+	unspent := make(map[string]map[int]*UTXO)
 	for _, tx := range *txs {
-		txHashStr := fmt.Sprintf("%x", tx.Hash())
 		for _, in := range tx.TxIn {
 			txOutHashStr := fmt.Sprintf("%x", in.TxOutHash)
 			spent[txOutHashStr] = append(spent[txOutHashStr], in.TxOutIndex)
 		}
+	}
+	for _, tx := range *txs {
+		txHashStr := fmt.Sprintf("%x", tx.ID())
 		for outIdx, out := range tx.TxOut {
 			spentout := false
 			for _, spentIdx := range spent[txHashStr] {
@@ -176,31 +420,144 @@ func (txs *Txs) UnspentTxOuts(spent map[string][]int) map[string][]*TxOut {
 			if spentout {
 				continue
 			}
-			unspent[txHashStr] = append(unspent[txHashStr], out)
+			if unspent[txHashStr] == nil {
+				unspent[txHashStr] = make(map[int]*UTXO)
+			}
+			unspent[txHashStr][outIdx] = &UTXO{out, height, tx.IsCoinBase()}
 		}
 	}
 	return unspent
 }
 
-func CoinBaseTx(wallet *Wallet) *Tx {
-	txin := []*TxIn{&TxIn{}}
-	txout := []*TxOut{&TxOut{reward, wallet.PubKeyHash()}}
-	tx := &Tx{txin, txout}
-	tx.Sign(wallet)
-	return tx
+// CoinBaseTx pays block reward to payouts, one output each.
+func CoinBaseTx(payouts []*Payment, height int) *Tx {
+	txin := []*TxIn{&TxIn{nil, 0, Script{}.AddInt(int64(height)), SequenceFinal}}
+	txout := make([]*TxOut, 0, len(payouts))
+	for _, p := range payouts {
+		txout = append(txout, &TxOut{p.Amount, p.Script})
+	}
+	return &Tx{txin, txout, 0}
 }
 
-func TransferTx(from, to *Wallet, amount int, u *UTXOSet) *Tx {
-	txIn, total := u.TransferTxIn(from, amount)
-	if len(txIn) == 0 {
-		panic("TransferTx: Insufficient balance")
+func (tx *Tx) CoinBaseHeight() (int, bool) {
+	if !tx.IsCoinBase() {
+		return 0, false
 	}
-	txOut := []*TxOut{&TxOut{amount, to.PubKeyHash()}}
-	change := total - amount
-	if change > 0 {
-		txOut = append(txOut, &TxOut{change, from.PubKeyHash()})
+	instrs, err := tx.TxIn[0].Script.Parse()
+	if err != nil || len(instrs) == 0 {
+		return 0, false
+	}
+	if op := instrs[0].Op; op >= OP_1 && op <= OP_16 {
+		return int(op-OP_1) + 1, true
+	}
+	if instrs[0].Op > OP_PUSHDATA1 {
+		return 0, false
+	}
+	height, err := DecodeScriptNum(instrs[0].Data, maxNumSize)
+	if err != nil {
+		return 0, false
+	}
+	return int(height), true
+}
+
+type Payment struct {
+	Script Script
+	Amount Amount
+}
+
+func TransferTx(from *Wallet, to Script, amount, feeRate Amount, height int, u *UTXOSet, cc *CoinControl) (*Tx, Amount, error) {
+	return PaymentTx(from, []*Payment{&Payment{to, amount}}, feeRate, height, u, cc)
+}
+
+// PaymentTx pays all payments from wallet with a single change output and
+// returns the transaction with its fee, which is at least feeRate per 1000 bytes.
+func PaymentTx(from *Wallet, payments []*Payment, feeRate Amount, height int, u *UTXOSet, cc *CoinControl) (*Tx, Amount, error) {
+	amount := Amount(0)
+	for _, p := range payments {
+		var err error
+		if amount, err = SumAmounts(amount, p.Amount); err != nil {
+			return nil, 0, err
+		}
+	}
+	fee := Amount(0)
+	for {
+		txIn, total, err := u.TransferTxIn(from.LockScript(), amount+fee, height, cc)
+		if err != nil {
+			return nil, 0, err
+		}
+		txOut := make([]*TxOut, 0, len(payments)+1)
+		for _, p := range payments {
+			txOut = append(txOut, &TxOut{p.Amount, p.Script})
+		}
+		change := total - amount - fee
+		if change >= DustThreshold {
+			txOut = append(txOut, &TxOut{change, from.LockScript()})
+		} else {
+			change = 0
+		}
+		tx := &Tx{txIn, txOut, 0}
+		tx.Sign(from)
+		required := feeRate * Amount(len(tx.Bytes())) / 1000
+		if total-amount-change >= required {
+			return tx, total - amount - change, nil
+		}
+		fee = required
+	}
+}
+
+// maxSigSize is the size of the largest signature with its sighash type,
+// which estimates fees of transactions before they are signed.
+const maxSigSize = 73
+
+// unlockSizeScript returns an unlocking script of spending lock with redeem
+// as large as a signed one, filled with placeholder signatures.
+func unlockSizeScript(lock, redeem Script) Script {
+	sig := make([]byte, maxSigSize)
+	if lock.ScriptHash() == nil {
+		return Script{}.AddData(sig).AddData(make([]byte, 64))
+	}
+	script := Script{}
+	m, _, _ := redeem.Multisig()
+	for range m {
+		script = script.AddData(sig)
+	}
+	return script.AddData(redeem)
+}
+
+// PartialTransferTx pays amount from a script, which is spent with redeem
+// when it pays to a script hash, and returns the transaction to be signed
+// with its fee, which is at least feeRate per 1000 bytes of the signed
+// transaction.
+func PartialTransferTx(from, redeem, to Script, amount, feeRate Amount, height int, u *UTXOSet, cc *CoinControl) (*PartialTx, Amount, error) {
+	fee := Amount(0)
+	for {
+		txIn, total, err := u.TransferTxIn(from, amount+fee, height, cc)
+		if err != nil {
+			return nil, 0, err
+		}
+		txOut := []*TxOut{&TxOut{amount, to}}
+		change := total - amount - fee
+		if change >= DustThreshold {
+			txOut = append(txOut, &TxOut{change, from})
+		} else {
+			change = 0
+		}
+		tx := &Tx{txIn, txOut, 0}
+		for _, in := range txIn {
+			in.Script = unlockSizeScript(from, redeem)
+		}
+		required := feeRate * Amount(len(tx.Bytes())) / 1000
+		if total-amount-change < required {
+			fee = required
+			continue
+		}
+		prevOuts := make([]*TxOut, 0, len(txIn))
+		redeems := make([]Script, 0, len(txIn))
+		for _, in := range txIn {
+			in.Script = nil
+			prevOuts = append(prevOuts, u.TxOut(in.TxOutHash, in.TxOutIndex))
+			redeems = append(redeems, redeem)
+		}
+		return NewPartialTx(tx, prevOuts, redeems), total - amount - change, nil
 	}
-	tx := &Tx{txIn, txOut}
-	tx.Sign(from)
-	return tx
 }
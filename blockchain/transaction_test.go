@@ -0,0 +1,152 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func newTestWallet() *Wallet {
+	ws := make(Wallets)
+	return ws.NewWallet()
+}
+
+// testTx spends two outputs locked to w into two outputs.
+func testTx(w *Wallet) (*Tx, []*TxOut) {
+	prevOuts := []*TxOut{{5 * Coin, w.LockScript()}, {3 * Coin, w.LockScript()}}
+	tx := &Tx{
+		TxIn: []*TxIn{
+			{bytes.Repeat([]byte{0xaa}, hashSize), 0, nil, SequenceFinal},
+			{bytes.Repeat([]byte{0xbb}, hashSize), 1, nil, SequenceFinal},
+		},
+		TxOut: []*TxOut{{4 * Coin, w.LockScript()}, {3 * Coin, w.LockScript()}},
+	}
+	return tx, prevOuts
+}
+
+func TestSigHash(t *testing.T) {
+	w := newTestWallet()
+	tests := []struct {
+		flag SigHashType
+		// change is made after input 0 is signed, and valid reports
+		// whether the signature still holds.
+		change func(tx *Tx)
+		valid  bool
+	}{
+		{SigHashAll, func(tx *Tx) {}, true},
+		{SigHashAll, func(tx *Tx) { tx.TxOut[1].Value-- }, false},
+		{SigHashAll, func(tx *Tx) { tx.TxIn[1].Sequence-- }, false},
+		{SigHashNone, func(tx *Tx) { tx.TxOut[0].Value-- }, true},
+		{SigHashNone, func(tx *Tx) { tx.TxIn[1].Sequence-- }, true},
+		{SigHashNone, func(tx *Tx) { tx.TxIn[1].TxOutIndex++ }, false},
+		{SigHashSingle, func(tx *Tx) { tx.TxOut[1].Value-- }, true},
+		{SigHashSingle, func(tx *Tx) { tx.TxOut[0].Value-- }, false},
+		{SigHashAll | SigHashAnyoneCanPay, func(tx *Tx) { tx.TxIn = tx.TxIn[:1] }, true},
+		{SigHashAll | SigHashAnyoneCanPay, func(tx *Tx) { tx.TxOut[1].Value-- }, false},
+		{SigHashSingle | SigHashAnyoneCanPay, func(tx *Tx) {
+			tx.TxIn = tx.TxIn[:1]
+			tx.TxOut[1].Value--
+		}, true},
+		{SigHashAll, func(tx *Tx) { tx.LockTime = 1 }, false},
+	}
+	for i, test := range tests {
+		tx, prevOuts := testTx(w)
+		if err := tx.SignInput(0, w, test.flag); err != nil {
+			t.Fatalf("%v: %v", i, err)
+		}
+		test.change(tx)
+		if valid := tx.VerifyInput(0, prevOuts[0]); valid != test.valid {
+			t.Errorf("%v: signature with type %#x is valid %v, want %v", i, byte(test.flag), valid, test.valid)
+		}
+	}
+}
+
+func TestSigHashUndefined(t *testing.T) {
+	w := newTestWallet()
+	tx, _ := testTx(w)
+	tx.TxOut = tx.TxOut[:1]
+	if tx.SigHash(1, SigHashSingle) != nil {
+		t.Error("SINGLE signature hash of input without output is defined")
+	}
+	if err := tx.SignInput(1, w, SigHashSingle); err == nil {
+		t.Error("input without output is signed with SINGLE")
+	}
+	if tx.SigHash(2, SigHashAll) != nil || tx.SigHash(0, 0x04) != nil {
+		t.Error("signature hash of unknown input or type is defined")
+	}
+	ptx := NewPartialTx(tx, []*TxOut{{5 * Coin, w.LockScript()}, {3 * Coin, w.LockScript()}}, []Script{nil, nil})
+	if _, err := ptx.Sign(w, SigHashSingle); err == nil {
+		t.Error("partial transaction is signed with SINGLE for input without output")
+	}
+}
+
+func TestParseSigHashType(t *testing.T) {
+	tests := []struct {
+		s    string
+		flag SigHashType
+		ok   bool
+	}{
+		{"ALL", SigHashAll, true},
+		{"none", SigHashNone, true},
+		{"SINGLE|ANYONECANPAY", SigHashSingle | SigHashAnyoneCanPay, true},
+		{"ANYONECANPAY", 0, false},
+		{"ALL|SOME", 0, false},
+	}
+	for _, test := range tests {
+		flag, err := ParseSigHashType(test.s)
+		if (err == nil) != test.ok || flag != test.flag {
+			t.Errorf("ParseSigHashType(%q) = %#x, %v", test.s, byte(flag), err)
+		}
+	}
+}
+
+func TestTxIDExcludesUnlockingScripts(t *testing.T) {
+	w := newTestWallet()
+	tx, prevOuts := testTx(w)
+	id := tx.ID()
+	tx.Sign(w)
+	if !bytes.Equal(tx.ID(), id) {
+		t.Error("signatures change transaction ID")
+	}
+	for idx := range tx.TxIn {
+		if !tx.VerifyInput(idx, prevOuts[idx]) {
+			t.Errorf("input %v does not verify", idx)
+		}
+	}
+}
+
+func TestPartialTransferTxFee(t *testing.T) {
+	signers := []*Wallet{newTestWallet(), newTestWallet(), newTestWallet()}
+	ms, err := NewMultisig(2, [][]byte{signers[0].PubKey(), signers[1].PubKey(), signers[2].PubKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := UTXOSet{}
+	for i := range 3 {
+		u[fmt.Sprintf("%x", bytes.Repeat([]byte{byte(i + 1)}, hashSize))] = map[int]*UTXO{0: {&TxOut{5 * Coin, ms.LockScript()}, 0, false}}
+	}
+	const feeRate = 100000
+	ptx, fee, err := PartialTransferTx(ms.LockScript(), ms.RedeemScript(), signers[0].LockScript(), 12*Coin, feeRate, 1, &u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range signers[:2] {
+		if _, err := ptx.Sign(w, SigHashAll); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx, err := ptx.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := Amount(0)
+	for _, o := range tx.TxOut {
+		out += o.Value
+	}
+	if fee != Amount(len(tx.TxIn))*5*Coin-out {
+		t.Errorf("fee is %v, inputs pay %v", fee, Amount(len(tx.TxIn))*5*Coin-out)
+	}
+	if required := Amount(feeRate * len(tx.Bytes()) / 1000); fee < required {
+		t.Errorf("fee %v is below %v of signed transaction", fee, required)
+	}
+}
@@ -1,15 +1,24 @@
 package blockchain
 
 import (
-	"bytes"
-	"encoding/binary"
+	"crypto/sha256"
 )
 
-func IntToBytes(n int) []byte {
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, int64(n))
-	if err != nil {
-		panic(err)
+func MerkleRoot(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		return nil
 	}
-	return buf.Bytes()
+	level := hashes
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([][]byte, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			hash := sha256.Sum256(append(append([]byte(nil), level[i]...), level[i+1]...))
+			next = append(next, hash[:])
+		}
+		level = next
+	}
+	return level[0]
 }
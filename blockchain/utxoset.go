@@ -2,51 +2,69 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
+	"sort"
 )
 
-type UTXOSet map[string][]*TxOut
+type UTXO struct {
+	*TxOut
+	Height   int
+	CoinBase bool
+}
+
+func (utxo *UTXO) Mature(height int) bool {
+	return !utxo.CoinBase || height-utxo.Height >= params.CoinbaseMaturity
+}
+
+type UTXOSet map[string]map[int]*UTXO
 
 func (u *UTXOSet) Index(bc *Blockchain) {
-	*u = make(map[string][]*TxOut)
-	unspent := bc.UnspentTxOuts()
-	for txHashStr, outs := range unspent {
-		for _, out := range outs {
-			(*u)[txHashStr] = append((*u)[txHashStr], out)
-		}
-	}
+	*u = bc.UnspentTxOuts()
 }
 
-func (u *UTXOSet) Update(tx *Tx) {
-	txHashStr := fmt.Sprintf("%x", tx.Hash())
+func (u *UTXOSet) Update(tx *Tx, height int) {
+	txHashStr := fmt.Sprintf("%x", tx.ID())
 	for _, in := range tx.TxIn {
 		txOutHashStr := fmt.Sprintf("%x", in.TxOutHash)
-		newOuts := make([]*TxOut, 0)
-		for idx, out := range (*u)[txOutHashStr] {
-			if idx != in.TxOutIndex {
-				newOuts = append(newOuts, out)
-			}
-		}
-		if len(newOuts) > 0 {
-			(*u)[txOutHashStr] = newOuts
-		} else {
+		delete((*u)[txOutHashStr], in.TxOutIndex)
+		if len((*u)[txOutHashStr]) == 0 {
 			delete(*u, txOutHashStr)
 		}
 	}
-	newOuts := make([]*TxOut, 0)
-	for _, out := range tx.TxOut {
-		newOuts = append(newOuts, out)
+	newOuts := make(map[int]*UTXO)
+	for idx, out := range tx.TxOut {
+		newOuts[idx] = &UTXO{out, height, tx.IsCoinBase()}
 	}
 	(*u)[txHashStr] = newOuts
 }
 
-func (u *UTXOSet) UnspentTxOuts(w *Wallet) []*TxOut {
-	unspent := make([]*TxOut, 0)
+// Apply adds outputs of all transactions before removing spent ones, so that
+// transactions of a block may come in any order.
+func (u *UTXOSet) Apply(txs Txs, height int) {
+	for _, tx := range txs {
+		txHashStr := fmt.Sprintf("%x", tx.ID())
+		(*u)[txHashStr] = make(map[int]*UTXO)
+		for idx, out := range tx.TxOut {
+			(*u)[txHashStr][idx] = &UTXO{out, height, tx.IsCoinBase()}
+		}
+	}
+	for _, tx := range txs {
+		for _, in := range tx.TxIn {
+			txOutHashStr := fmt.Sprintf("%x", in.TxOutHash)
+			delete((*u)[txOutHashStr], in.TxOutIndex)
+			if len((*u)[txOutHashStr]) == 0 {
+				delete(*u, txOutHashStr)
+			}
+		}
+	}
+}
+
+func (u *UTXOSet) UnspentTxOuts(lock Script) []*UTXO {
+	unspent := make([]*UTXO, 0)
 	for _, outs := range *u {
 		for _, out := range outs {
-			if out.LockedWith(w) {
+			if out.LockedWith(lock) {
 				unspent = append(unspent, out)
 			}
 		}
@@ -54,58 +72,151 @@ func (u *UTXOSet) UnspentTxOuts(w *Wallet) []*TxOut {
 	return unspent
 }
 
-func (u *UTXOSet) SpendableTxOuts(w *Wallet, amount int) (map[string][]int, int) {
-	unspent := make(map[string][]int)
-	total := 0
-	for txHashStr, outs := range *u {
-		for idx, out := range outs {
-			if out.LockedWith(w) {
-				unspent[txHashStr] = append(unspent[txHashStr], idx)
-				total += out.Value
-			}
-			if total >= amount {
-				return unspent, total
+// UnspentOuts returns outputs locked with lock ordered by outpoint.
+func (u *UTXOSet) UnspentOuts(lock Script) []*UnspentOut {
+	outs := make([]*UnspentOut, 0)
+	for txHashStr, utxos := range *u {
+		txHash, err := hex.DecodeString(txHashStr)
+		if err != nil {
+			panic(err)
+		}
+		for idx, utxo := range utxos {
+			if utxo.LockedWith(lock) {
+				outs = append(outs, &UnspentOut{txHash, idx, utxo})
 			}
 		}
 	}
-	return nil, 0
+	sort.Slice(outs, func(i, j int) bool {
+		if c := bytes.Compare(outs[i].TxHash, outs[j].TxHash); c != 0 {
+			return c < 0
+		}
+		return outs[i].Index < outs[j].Index
+	})
+	return outs
 }
 
-func (u *UTXOSet) TransferTxIn(from *Wallet, amount int) ([]*TxIn, int) {
-	spendable, total := u.SpendableTxOuts(from, amount)
-	if len(spendable) == 0 || total < amount {
-		return nil, 0
+func (u *UTXOSet) Spendable(lock Script, height int) []*UnspentOut {
+	spendable := make([]*UnspentOut, 0)
+	for _, out := range u.UnspentOuts(lock) {
+		if out.Mature(height) {
+			spendable = append(spendable, out)
+		}
 	}
-	inputs := make([]*TxIn, 0)
-	for txHashStr := range spendable {
-		txHash, err := hex.DecodeString(txHashStr)
-		if err != nil {
-			panic(err)
+	return spendable
+}
+
+func (u *UTXOSet) TransferTxIn(from Script, amount Amount, height int, cc *CoinControl) ([]*TxIn, Amount, error) {
+	if cc == nil {
+		cc = &CoinControl{}
+	}
+	selected := make([]*UnspentOut, 0)
+	pinned := make(map[string]bool)
+	total := Amount(0)
+	for _, in := range cc.Inputs {
+		utxo := u.UTXO(in.TxOutHash, in.TxOutIndex)
+		op := outpoint(in.TxOutHash, in.TxOutIndex)
+		if utxo == nil || !utxo.LockedWith(from) || pinned[op] {
+			return nil, 0, fmt.Errorf("output %v is not spendable by sender", op)
 		}
-		for _, idx := range spendable[txHashStr] {
-			inputs = append(inputs, &TxIn{txHash, idx, nil, nil})
+		if !utxo.Mature(height) {
+			return nil, 0, fmt.Errorf("output %v is immature", op)
 		}
+		pinned[op] = true
+		selected = append(selected, &UnspentOut{in.TxOutHash, in.TxOutIndex, utxo})
+		total += utxo.Value
+	}
+	if total < amount || len(selected) == 0 {
+		coins := make([]*UnspentOut, 0)
+		for _, coin := range u.Spendable(from, height) {
+			if !pinned[coin.Outpoint()] && !cc.Locked.Locked(coin.TxHash, coin.Index) {
+				coins = append(coins, coin)
+			}
+		}
+		more, err := SelectCoins(cc.Strategy, coins, amount-total)
+		if err != nil {
+			return nil, 0, err
+		}
+		selected = append(selected, more...)
+	}
+	sequence := SequenceFinal
+	if cc.RBF {
+		sequence = SequenceRBF
+	}
+	inputs := make([]*TxIn, 0, len(selected))
+	total = 0
+	for _, coin := range selected {
+		inputs = append(inputs, &TxIn{coin.TxHash, coin.Index, nil, sequence})
+		total += coin.Value
+	}
+	return inputs, total, nil
+}
+
+func (u *UTXOSet) TxOut(txHash []byte, idx int) *TxOut {
+	utxo := u.UTXO(txHash, idx)
+	if utxo == nil {
+		return nil
 	}
-	return inputs, total
+	return utxo.TxOut
+}
+
+func (u *UTXOSet) UTXO(txHash []byte, idx int) *UTXO {
+	return (*u)[fmt.Sprintf("%x", txHash)][idx]
 }
 
 func (u *UTXOSet) Serialize() []byte {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(u)
-	if err != nil {
-		panic(err)
+	txHashStrs := make([]string, 0, len(*u))
+	for txHashStr := range *u {
+		txHashStrs = append(txHashStrs, txHashStr)
+	}
+	sort.Strings(txHashStrs)
+	e := &Encoder{}
+	e.WriteVarInt(uint64(len(txHashStrs)))
+	for _, txHashStr := range txHashStrs {
+		txHash, err := hex.DecodeString(txHashStr)
+		if err != nil {
+			panic(err)
+		}
+		outs := (*u)[txHashStr]
+		idxs := make([]int, 0, len(outs))
+		for idx := range outs {
+			idxs = append(idxs, idx)
+		}
+		sort.Ints(idxs)
+		e.WriteBytes(txHash)
+		e.WriteVarInt(uint64(len(idxs)))
+		for _, idx := range idxs {
+			e.WriteUint32(uint32(idx))
+			e.Write(outs[idx].Bytes())
+			e.WriteInt64(int64(outs[idx].Height))
+			coinBase := uint32(0)
+			if outs[idx].CoinBase {
+				coinBase = 1
+			}
+			e.WriteUint32(coinBase)
+		}
 	}
-	return buf.Bytes()
+	return e.Bytes()
 }
 
 func UTXOSetDeserialize(data []byte) *UTXOSet {
 	u := &UTXOSet{}
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
-	err := dec.Decode(u)
-	if err != nil {
-		panic(err)
+	d := NewDecoder(data)
+	n := d.ReadCount(2)
+	for i := 0; i < n; i++ {
+		txHashStr := fmt.Sprintf("%x", d.ReadBytes())
+		m := d.ReadCount(25)
+		outs := make(map[int]*UTXO)
+		for j := 0; j < m; j++ {
+			idx := int(d.ReadUint32())
+			out := d.ReadTxOut()
+			height := int(d.ReadInt64())
+			coinBase := d.ReadUint32() != 0
+			outs[idx] = &UTXO{out, height, coinBase}
+		}
+		(*u)[txHashStr] = outs
+	}
+	if d.Err() != nil {
+		panic(d.Err())
 	}
 	return u
 }
@@ -8,6 +8,7 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/gob"
+	"errors"
 
 	"blockchain/base58"
 
@@ -15,8 +16,9 @@ import (
 )
 
 const (
-	version = 00
-	cslen   = 4
+	version       = 00
+	scriptVersion = 05
+	cslen         = 4
 )
 
 type Wallet ecdsa.PrivateKey
@@ -34,23 +36,72 @@ func (w *Wallet) Bytes() []byte {
 }
 
 func (w *Wallet) Address() string {
-	pubKeyHash := w.PubKeyHash()
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+	return Address(version, w.PubKeyHash())
+}
+
+func Address(ver byte, hash []byte) string {
+	versionedPayload := append([]byte{ver}, hash...)
 	checksum := Checksum(versionedPayload)
 	fullPayload := append(versionedPayload, checksum...)
 	address := base58.Encode(fullPayload)
 	return string(address)
 }
 
+func AddressScript(address string) (Script, error) {
+	fullPayload := base58.Decode([]byte(address))
+	if len(fullPayload) != 1+20+cslen {
+		return nil, errors.New("invalid address length")
+	}
+	versionedPayload := fullPayload[:len(fullPayload)-cslen]
+	checksum := fullPayload[len(fullPayload)-cslen:]
+	if !bytes.Equal(Checksum(versionedPayload), checksum) {
+		return nil, errors.New("invalid address checksum")
+	}
+	switch versionedPayload[0] {
+	case version:
+		return PayToPubKeyHash(versionedPayload[1:]), nil
+	case scriptVersion:
+		return PayToScriptHash(versionedPayload[1:]), nil
+	}
+	return nil, errors.New("unknown address version")
+}
+
+func ScriptAddress(s Script) string {
+	if hash := s.PubKeyHash(); hash != nil {
+		return Address(version, hash)
+	}
+	if hash := s.ScriptHash(); hash != nil {
+		return Address(scriptVersion, hash)
+	}
+	return ""
+}
+
+func (w *Wallet) PubKey() []byte {
+	pubKey := make([]byte, 64)
+	w.X.FillBytes(pubKey[:32])
+	w.Y.FillBytes(pubKey[32:])
+	return pubKey
+}
+
+func (w *Wallet) LockScript() Script {
+	return PayToPubKeyHash(w.PubKeyHash())
+}
+
 func (w *Wallet) PubKeyHash() []byte {
-	h := sha256.New()
-	pubKeyBytes := w.X.Bytes()
-	h.Write(pubKeyBytes)
-	shabytes := h.Sum(nil)
+	return Hash160(w.PubKey())
+}
+
+// LegacyAddress is the address wallet had before outputs were locked with
+// scripts, when public key hash covered only X coordinate of the key.
+func (w *Wallet) LegacyAddress() string {
+	return Address(version, Hash160(w.X.Bytes()))
+}
+
+func Hash160(data []byte) []byte {
+	shabytes := sha256.Sum256(data)
 	r := ripemd160.New()
-	r.Write(shabytes)
-	ripebytes := r.Sum(nil)
-	return ripebytes
+	r.Write(shabytes[:])
+	return r.Sum(nil)
 }
 
 func Checksum(payload []byte) []byte {
@@ -129,8 +180,11 @@ func WalletsDeserialize(data []byte) *Wallets {
 	if err != nil {
 		panic(err)
 	}
-	for k, v := range wss {
-		(*ws)[k] = WalletDeserialize(v)
+	// Wallets are keyed by their current address, which migrates wallets
+	// stored under legacy addresses.
+	for _, v := range wss {
+		w := WalletDeserialize(v)
+		(*ws)[w.Address()] = w
 	}
 	return ws
 }
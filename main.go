@@ -11,9 +11,24 @@ func main() {
 		fmt.Printf(
 			"Usage:  blockchain command args...\n\t" +
 				"wallet - manage wallets\n\t" +
-				"mine - mine transactions from pool into block\n\t" +
+				"bft - inspect BFT validators\n\t" +
+				"estimatefee - estimate fee rate to confirm within target blocks\n\t" +
+				"getsyncstatus - report progress of the last sync\n\t" +
+				"mempool - inspect and manage unconfirmed transactions\n\t" +
+				"mine - mine transactions from mempool into block\n\t" +
+				"peers - manage known peers and bans\n\t" +
+				"poa - manage proof of authority signers\n\t" +
+				"pool - serve a mining pool with PPLNS payouts\n\t" +
+				"powbench - measure hashrate of proof of work functions\n\t" +
 				"print - print blockchain data\n\t" +
+				"rawtx - create, decode, sign and send raw transactions\n\t" +
 				"send - record a transfer transaction\n\t" +
+				"sendmany - pay many recipients in one transaction\n\t" +
+				"serve - serve HTTP API for external miners, syncing peers and BFT validators\n\t" +
+				"stake - manage proof of stake\n\t" +
+				"supply - print issued supply and subsidy schedule\n\t" +
+				"sync - download blockchain from peers headers first\n\t" +
+				"tx - create, sign and submit partially signed transactions\n\t" +
 				"verify - verify a blockchain integrity\n",
 		)
 		return
@@ -23,12 +38,42 @@ func main() {
 	switch method {
 	case "wallet":
 		blockchain.Wallet_(args)
+	case "bft":
+		blockchain.BFT_(args)
+	case "estimatefee":
+		blockchain.EstimateFee(args)
+	case "getsyncstatus":
+		blockchain.GetSyncStatus()
+	case "mempool":
+		blockchain.Mempool_(args)
 	case "mine":
 		blockchain.Mine(args)
+	case "peers":
+		blockchain.Peers_(args)
+	case "poa":
+		blockchain.PoA_(args)
+	case "pool":
+		blockchain.Pool(args)
+	case "powbench":
+		blockchain.PoWBench(args)
 	case "print":
 		blockchain.Print()
+	case "rawtx":
+		blockchain.RawTx_(args)
 	case "send":
 		blockchain.Send(args)
+	case "sendmany":
+		blockchain.SendMany(args)
+	case "serve":
+		blockchain.Serve(args)
+	case "stake":
+		blockchain.Stake_(args)
+	case "supply":
+		blockchain.Supply()
+	case "sync":
+		blockchain.Sync(args)
+	case "tx":
+		blockchain.Tx_(args)
 	case "verify":
 		blockchain.Verify()
 	}